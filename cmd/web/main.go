@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"log/slog"
@@ -13,15 +15,17 @@ import (
 	"time"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/ferretcode/scavenger/internal/api"
 	"github.com/ferretcode/scavenger/internal/auth"
 	"github.com/ferretcode/scavenger/internal/bootstrap"
+	"github.com/ferretcode/scavenger/internal/history"
 	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/internal/scheduler"
+	"github.com/ferretcode/scavenger/internal/webhook"
 	"github.com/ferretcode/scavenger/internal/websocket"
-	"github.com/ferretcode/scavenger/internal/workflow"
 	"github.com/ferretcode/scavenger/pkg/types"
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
@@ -39,6 +43,9 @@ func parseTemplates() error {
 		"./views/workflows.html",
 		"./views/login.html",
 		"./views/api.html",
+		"./views/users.html",
+		"./views/sessions.html",
+		"./views/webhooks.html",
 	}
 
 	templates, err = template.ParseFiles(files...)
@@ -95,15 +102,44 @@ func main() {
 	defer cancel()
 	_ = db.Ping(pingCtx, readpref.Primary())
 
-	dashboardCardData := types.DashboardCardData{
-		DocScraped:  0,
-		CliConnects: 0,
-	}
+	dashboardCardData := types.DashboardCardData{}
 
 	r := chi.NewRouter()
 
-	authService := auth.NewAuthService(&config)
-	websocketService := websocket.NewWebsocketService(&config, db, logger, ctx, &dashboardCardData)
+	sessionStore, err := auth.NewSessionStore(config.SessionStore, db, config.DatabaseName, ctx)
+	if err != nil {
+		logger.Error("error initializing session store", "err", err)
+		return
+	}
+
+	authService := auth.NewAuthService(&config, db, logger, ctx, sessionStore)
+
+	if err := authService.EnsureUsers(); err != nil {
+		logger.Error("error initializing users", "err", err)
+		return
+	}
+
+	if err := authService.EnsureAPIKeys(); err != nil {
+		logger.Error("error initializing api keys", "err", err)
+		return
+	}
+
+	historyService := history.NewHistoryService(&config, db, logger, ctx)
+
+	if err := historyService.EnsureIndexes(); err != nil {
+		logger.Error("error creating history indexes", "err", err)
+	}
+
+	webhookService := webhook.NewWebhookService(&config, db, logger, ctx)
+
+	if err := webhookService.EnsureIndexes(); err != nil {
+		logger.Error("error creating webhook indexes", "err", err)
+	}
+
+	// one upstream connection per workflow, shared by clients and the recorder
+	hubs := websocket.NewHubManager(&config, db, logger, ctx, &historyService, &webhookService, &dashboardCardData)
+	websocketService := websocket.NewWebsocketService(&config, db, logger, ctx, hubs, &historyService, &dashboardCardData)
+
 	var serviceProvider infrastructure.ServiceProvider
 
 	switch strings.ToLower(config.Provider) {
@@ -122,26 +158,45 @@ func main() {
 		}
 		defer localServiceProvider.Close()
 		serviceProvider = localServiceProvider
-
-		errors := bootstrap.Bootstrap(serviceProvider, logger)
-		for _, err := range errors {
-			if err != nil {
-				logger.Error("error bootstrapping workflows from configuration", "err", err)
-				return
-			}
-		}
-
 		break
 	default:
 		logger.Error("error selecting provider. invalid provider provided")
+		return
+	}
+
+	serviceProvider.OnWorkflowDeleted(webhookService.DeleteWorkflowWebhooks)
+
+	reconciler := bootstrap.NewReconciler(&config, db, logger, ctx, serviceProvider)
+
+	// a broken config entry or a failed step leaves those workflows as they
+	// are, the rest of the server still starts
+	_, reconcileErrs := reconciler.Reconcile(bootstrap.TriggerStartup)
+	for _, err := range reconcileErrs {
+		logger.Error("error reconciling workflows from configuration", "err", err)
 	}
 
+	go reconciler.Watch(5 * time.Second)
+
+	apiService := api.NewAPIService(&config, db, logger, ctx, serviceProvider, &historyService)
+
+	recorder := websocket.NewRecorder(&config, db, logger, ctx, hubs)
+	go recorder.Run(30 * time.Second)
+
+	workflowScheduler := scheduler.NewScheduler(&config, db, logger, ctx)
+	go workflowScheduler.Run(15 * time.Second)
+
+	go webhookService.Run(time.Second)
+
 	registerRoutes(
 		r,
 		Services{
+			APIService:       apiService,
 			AuthService:      authService,
 			ServiceProvider:  serviceProvider,
 			WebsocketService: websocketService,
+			HistoryService:   historyService,
+			WebhookService:   webhookService,
+			Reconciler:       reconciler,
 		},
 		db,
 		ctx,
@@ -156,32 +211,29 @@ func main() {
 	}
 }
 
-func getWorkflows(db *mongo.Client) ([]workflow.Workflow, error) {
-	cur, err := db.Database(config.DatabaseName).Collection("workflows").Find(context.Background(), bson.D{{}})
-	if err != nil {
-		return nil, err
-	}
-	defer cur.Close(context.Background())
-
-	var workflows []workflow.Workflow
-	for cur.Next(context.Background()) {
-		var workflow workflow.Workflow
-		if err := cur.Decode(&workflow); err != nil {
-			log.Fatal(err)
-		}
-		workflows = append(workflows, workflow)
-	}
-
-	return workflows, cur.Err()
+func getWorkflows(db *mongo.Client) ([]infrastructure.Workflow, error) {
+	return infrastructure.ListWorkflows(context.Background(), db, config.DatabaseName)
 }
 
 func handleError(err error, w http.ResponseWriter, svc string) {
+	var validationErr *infrastructure.ValidationError
+	if errors.As(err, &validationErr) {
+		http.Error(w, validationErr.Message, http.StatusBadRequest)
+		return
+	}
+
 	if err != nil {
 		http.Error(w, "there was an error processing your request", http.StatusInternalServerError)
 		logger.Error("error processing request", "svc", svc, "err", err)
 	}
 }
 
+func writeJSON(w http.ResponseWriter, status int, data any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(data)
+}
+
 func proxyToUri(hostString string) (*httputil.ReverseProxy, error) {
 	url, err := url.Parse(hostString)
 	if err != nil {
@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"net/http"
+	"time"
 
+	"github.com/ferretcode/scavenger/internal/api"
 	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/bootstrap"
 	"github.com/ferretcode/scavenger/internal/dashboard"
+	"github.com/ferretcode/scavenger/internal/history"
 	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/internal/webhook"
 	"github.com/ferretcode/scavenger/internal/websocket"
 	"github.com/ferretcode/scavenger/pkg/types"
 	"github.com/go-chi/chi/v5"
@@ -14,9 +19,13 @@ import (
 )
 
 type Services struct {
+	APIService       api.APIService
 	AuthService      auth.AuthService
 	ServiceProvider  infrastructure.ServiceProvider
 	WebsocketService websocket.WebsocketService
+	HistoryService   history.HistoryService
+	WebhookService   webhook.WebhookService
+	Reconciler       *bootstrap.Reconciler
 }
 
 func registerRoutes(
@@ -39,8 +48,13 @@ func registerRoutes(
 		}
 
 		data.TopCardData = dashboard.GetTopDashData(services.ServiceProvider, ctx)
-		data.TopCardData.DocumentsScraped = dashboardCardData.DocScraped
-		data.TopCardData.ClientConnections = dashboardCardData.CliConnects
+		data.TopCardData.DocumentsScraped = int(dashboardCardData.DocScraped.Load())
+		data.TopCardData.ClientConnections = int(dashboardCardData.CliConnects.Load())
+
+		if services.Reconciler != nil {
+			data.Reconcile = services.Reconciler.Status()
+		}
+
 		handleError(templates.ExecuteTemplate(w, "dashboard.html", data), w, "dashboard/render")
 	})
 
@@ -52,6 +66,7 @@ func registerRoutes(
 
 	r.Route("/workflows", func(r chi.Router) {
 		r.Use(services.AuthService.RequireAuth)
+		r.Use(services.AuthService.RequireRole(auth.RoleEditor))
 
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 			workflows, err := getWorkflows(db)
@@ -72,15 +87,98 @@ func registerRoutes(
 			handleError(services.ServiceProvider.CreateWorkflow(w, r), w, "workflow/create")
 		})
 
+		r.Post("/update", func(w http.ResponseWriter, r *http.Request) {
+			handleError(services.ServiceProvider.UpdateWorkflow(w, r), w, "workflow/update")
+		})
+
 		r.Post("/delete", func(w http.ResponseWriter, r *http.Request) {
 			handleError(services.ServiceProvider.DeleteWorkflow(w, r), w, "workflow/delete")
 		})
+
+		r.Post("/pause", func(w http.ResponseWriter, r *http.Request) {
+			handleError(setWorkflowPaused(w, r, services.ServiceProvider, true), w, "workflow/pause")
+		})
+
+		r.Post("/resume", func(w http.ResponseWriter, r *http.Request) {
+			handleError(setWorkflowPaused(w, r, services.ServiceProvider, false), w, "workflow/resume")
+		})
+
+		r.Get("/cron/preview", func(w http.ResponseWriter, r *http.Request) {
+			runs, err := infrastructure.NextCronRuns(r.URL.Query().Get("expr"), time.Now(), 5)
+			if err != nil {
+				handleError(writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()}), w, "workflow/cron")
+				return
+			}
+
+			handleError(writeJSON(w, http.StatusOK, map[string]any{"next_runs": runs}), w, "workflow/cron")
+		})
+
+		r.Post("/{workflow_name}/run", func(w http.ResponseWriter, r *http.Request) {
+			handleError(runWorkflow(w, r, db, ctx), w, "workflow/run")
+		})
+
+		// the same handler as the api, signed in users can read every workflow
+		r.Get("/{workflow_name}/history", services.APIService.WorkflowHistory)
+
+		r.Route("/{workflow_name}/webhooks", func(r chi.Router) {
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.WebhookService.RenderWebhooks(w, r, templates), w, "webhooks/render")
+			})
+
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.WebhookService.CreateWebhook(w, r), w, "webhooks/create")
+			})
+
+			r.Post("/delete", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.WebhookService.DeleteWebhook(w, r), w, "webhooks/delete")
+			})
+
+			r.Post("/retry", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.WebhookService.RetryDelivery(w, r), w, "webhooks/retry")
+			})
+		})
 	})
 
-	r.With(services.AuthService.RequireAPIKey(ctx, db, logger, &config)).Get("/connect/{workflow_name}", func(w http.ResponseWriter, r *http.Request) {
+	requireScope := services.AuthService.RequireScope
+
+	r.With(
+		services.AuthService.RequireAPIKey(ctx, db, logger, &config),
+		requireScope(auth.ScopeSubscribe),
+	).Get("/connect/{workflow_name}", func(w http.ResponseWriter, r *http.Request) {
 		services.WebsocketService.HandleWorkflowConnection(w, r)
 	})
 
+	r.With(
+		services.AuthService.RequireAPIKey(ctx, db, logger, &config),
+		requireScope(auth.ScopeSubscribe),
+	).Get("/connect", func(w http.ResponseWriter, r *http.Request) {
+		services.WebsocketService.HandleMultiplexConnection(w, r)
+	})
+
+	r.With(
+		services.AuthService.RequireAPIKey(ctx, db, logger, &config),
+		requireScope(auth.ScopeSubscribe),
+	).Get("/events/{workflow_name}", func(w http.ResponseWriter, r *http.Request) {
+		services.WebsocketService.HandleEventStream(w, r)
+	})
+
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(services.AuthService.RequireAPIKey(ctx, db, logger, &config))
+
+		r.Route("/workflows", func(r chi.Router) {
+			// the list is filtered down to the workflows the key can access
+			r.Get("/", services.APIService.ListWorkflows)
+			r.With(requireScope(auth.ScopeManage)).Post("/", services.APIService.CreateWorkflow)
+			r.With(requireScope("")).Get("/{workflow_name}", services.APIService.GetWorkflow)
+			r.With(requireScope(auth.ScopeManage)).Put("/{workflow_name}", services.APIService.UpdateWorkflow)
+			r.With(requireScope(auth.ScopeManage)).Delete("/{workflow_name}", services.APIService.DeleteWorkflow)
+			r.With(requireScope(auth.ScopeManage)).Post("/{workflow_name}/run", services.APIService.RunWorkflow)
+			r.With(requireScope(auth.ScopeManage)).Post("/{workflow_name}/pause", services.APIService.PauseWorkflow)
+			r.With(requireScope(auth.ScopeManage)).Post("/{workflow_name}/resume", services.APIService.ResumeWorkflow)
+			r.With(requireScope(auth.ScopeReadHistory)).Get("/{workflow_name}/history", services.APIService.WorkflowHistory)
+		})
+	})
+
 	r.Route("/auth", func(r chi.Router) {
 		r.Get("/login", func(w http.ResponseWriter, r *http.Request) {
 			handleError(services.AuthService.RenderLogin(w, r, templates), w, "login/render")
@@ -96,14 +194,103 @@ func registerRoutes(
 
 		r.Route("/api", func(r chi.Router) {
 			r.Use(services.AuthService.RequireAuth)
+			r.Use(services.AuthService.RequireRole(auth.RoleEditor))
 
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-				handleError(services.AuthService.RenderAPIKey(w, r, templates, nil), w, "api/render")
+				handleError(services.AuthService.RenderAPIKey(w, r, templates, ""), w, "api/render")
 			})
 
 			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
 				handleError(services.AuthService.CreateAPIKey(w, r, db, templates, ctx), w, "api")
 			})
+
+			r.Post("/revoke", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.RevokeAPIKey(w, r), w, "api/revoke")
+			})
+		})
+
+		r.Route("/users", func(r chi.Router) {
+			r.Use(services.AuthService.RequireAuth)
+			r.Use(services.AuthService.RequireRole(auth.RoleAdmin))
+
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.RenderUsers(w, r, templates), w, "users/render")
+			})
+
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.CreateUser(w, r), w, "users/create")
+			})
+
+			r.Post("/role", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.UpdateUserRole(w, r), w, "users/role")
+			})
+
+			r.Post("/delete", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.DeleteUser(w, r), w, "users/delete")
+			})
+		})
+
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(services.AuthService.RequireAuth)
+			r.Use(services.AuthService.RequireRole(auth.RoleAdmin))
+
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.RenderSessions(w, r, templates), w, "sessions/render")
+			})
+
+			r.Post("/revoke", func(w http.ResponseWriter, r *http.Request) {
+				handleError(services.AuthService.RevokeSession(w, r), w, "sessions/revoke")
+			})
 		})
 	})
 }
+
+func setWorkflowPaused(w http.ResponseWriter, r *http.Request, serviceProvider infrastructure.ServiceProvider, paused bool) error {
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	workflowName := r.PostForm.Get("workflowName")
+
+	if paused {
+		err = serviceProvider.PauseWorkflow(workflowName)
+	} else {
+		err = serviceProvider.ResumeWorkflow(workflowName)
+	}
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/workflows", http.StatusSeeOther)
+
+	return nil
+}
+
+func runWorkflow(w http.ResponseWriter, r *http.Request, db *mongo.Client, ctx context.Context) error {
+	workflow, err := infrastructure.FindWorkflow(ctx, db, config.DatabaseName, chi.URLParam(r, "workflow_name"))
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return nil
+		}
+
+		return err
+	}
+
+	err = infrastructure.TriggerWorkflowRun(r.Context(), *workflow)
+	if err != nil {
+		if err == infrastructure.ErrWorkflowPaused {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return nil
+		}
+
+		return err
+	}
+
+	logger.Info("manual run triggered", "workflow-name", workflow.Name)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	return nil
+}
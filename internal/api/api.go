@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/history"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const maxRequestBodyBytes = 1 << 20
+
+type APIService struct {
+	Config          *types.ScavengerConfig
+	db              *mongo.Client
+	logger          *slog.Logger
+	ctx             context.Context
+	serviceProvider infrastructure.ServiceProvider
+	history         *history.HistoryService
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewAPIService(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+	serviceProvider infrastructure.ServiceProvider,
+	historyService *history.HistoryService,
+) APIService {
+	return APIService{
+		Config:          config,
+		db:              db,
+		logger:          logger,
+		ctx:             ctx,
+		serviceProvider: serviceProvider,
+		history:         historyService,
+	}
+}
+
+func (a *APIService) ListWorkflows(w http.ResponseWriter, r *http.Request) {
+	workflows, err := infrastructure.ListWorkflows(a.ctx, a.db, a.Config.DatabaseName)
+	if err != nil {
+		a.internalError(w, "workflows/list", err)
+		return
+	}
+
+	key, _ := auth.APIKeyFromContext(r.Context())
+
+	specs := make([]types.WorkflowsConfig, 0, len(workflows))
+	for _, workflow := range workflows {
+		if !key.Scopes.AllowsWorkflow(workflow.Name, workflow.Tags) {
+			continue
+		}
+
+		specs = append(specs, infrastructure.ConfigFromWorkflow(workflow))
+	}
+
+	writeJSON(w, http.StatusOK, specs)
+}
+
+func (a *APIService) GetWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflow, ok := a.findWorkflow(w, chi.URLParam(r, "workflow_name"))
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, infrastructure.ConfigFromWorkflow(*workflow))
+}
+
+func (a *APIService) CreateWorkflow(w http.ResponseWriter, r *http.Request) {
+	var spec types.WorkflowsConfig
+
+	if !decodeJSON(w, r, &spec) {
+		return
+	}
+
+	workflow, err := infrastructure.WorkflowFromConfig(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, _ := auth.APIKeyFromContext(r.Context())
+	if !key.Scopes.AllowsWorkflow(workflow.Name, workflow.Tags) {
+		writeError(w, http.StatusForbidden, "this api key cannot create workflows outside of its scope")
+		return
+	}
+
+	_, err = infrastructure.FindWorkflow(a.ctx, a.db, a.Config.DatabaseName, workflow.Name)
+	if err == nil {
+		writeError(w, http.StatusConflict, "a workflow with this name already exists")
+		return
+	}
+	if err != infrastructure.ErrNoWorkflowExists {
+		a.internalError(w, "workflows/create", err)
+		return
+	}
+
+	err = a.serviceProvider.CreateWorkflowFromConfig(workflow)
+	if err != nil {
+		a.internalError(w, "workflows/create", err)
+		return
+	}
+
+	created, ok := a.findWorkflow(w, workflow.Name)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, infrastructure.ConfigFromWorkflow(*created))
+}
+
+func (a *APIService) UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
+	existing, ok := a.findWorkflow(w, chi.URLParam(r, "workflow_name"))
+	if !ok {
+		return
+	}
+
+	var spec types.WorkflowsConfig
+
+	if !decodeJSON(w, r, &spec) {
+		return
+	}
+
+	if spec.Name == "" {
+		spec.Name = existing.Name
+	}
+
+	workflow, err := infrastructure.WorkflowFromConfig(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if workflow.Name != existing.Name {
+		writeError(w, http.StatusBadRequest, "workflows cannot be renamed")
+		return
+	}
+
+	// new tags could move the workflow into the scope of other keys or out
+	// of the scope of this one
+	key, _ := auth.APIKeyFromContext(r.Context())
+	if !key.Scopes.AllowsWorkflow(workflow.Name, workflow.Tags) {
+		writeError(w, http.StatusForbidden, "this api key cannot move workflows outside of its scope")
+		return
+	}
+
+	err = a.serviceProvider.UpdateWorkflowFromConfig(workflow)
+	if err != nil {
+		a.internalError(w, "workflows/update", err)
+		return
+	}
+
+	updated, ok := a.findWorkflow(w, workflow.Name)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, infrastructure.ConfigFromWorkflow(*updated))
+}
+
+func (a *APIService) DeleteWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflow, ok := a.findWorkflow(w, chi.URLParam(r, "workflow_name"))
+	if !ok {
+		return
+	}
+
+	err := a.serviceProvider.DeleteWorkflowByName(workflow.Name)
+	if err != nil {
+		a.internalError(w, "workflows/delete", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *APIService) RunWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflow, ok := a.findWorkflow(w, chi.URLParam(r, "workflow_name"))
+	if !ok {
+		return
+	}
+
+	err := infrastructure.TriggerWorkflowRun(r.Context(), *workflow)
+	if err != nil {
+		if err == infrastructure.ErrWorkflowPaused {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		a.logger.Error("error triggering workflow run", "workflow-name", workflow.Name, "err", err)
+		writeError(w, http.StatusBadGateway, "the workflow worker could not be reached")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+func (a *APIService) PauseWorkflow(w http.ResponseWriter, r *http.Request) {
+	a.setWorkflowPaused(w, r, true)
+}
+
+func (a *APIService) ResumeWorkflow(w http.ResponseWriter, r *http.Request) {
+	a.setWorkflowPaused(w, r, false)
+}
+
+func (a *APIService) setWorkflowPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	workflow, ok := a.findWorkflow(w, chi.URLParam(r, "workflow_name"))
+	if !ok {
+		return
+	}
+
+	var err error
+	if paused {
+		err = a.serviceProvider.PauseWorkflow(workflow.Name)
+	} else {
+		err = a.serviceProvider.ResumeWorkflow(workflow.Name)
+	}
+	if err != nil {
+		a.internalError(w, "workflows/status", err)
+		return
+	}
+
+	updated, ok := a.findWorkflow(w, workflow.Name)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, infrastructure.ConfigFromWorkflow(*updated))
+}
+
+func (a *APIService) WorkflowHistory(w http.ResponseWriter, r *http.Request) {
+	workflow, ok := a.findWorkflow(w, chi.URLParam(r, "workflow_name"))
+	if !ok {
+		return
+	}
+
+	limit := int64(50)
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		parsed, err := strconv.ParseInt(limitParam, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := a.history.List(workflow.Name, limit)
+	if err != nil {
+		a.internalError(w, "workflows/history", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// findWorkflow writes the error response itself and returns false
+// if the workflow could not be loaded
+func (a *APIService) findWorkflow(w http.ResponseWriter, workflowName string) (*infrastructure.Workflow, bool) {
+	workflow, err := infrastructure.FindWorkflow(a.ctx, a.db, a.Config.DatabaseName, workflowName)
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			writeError(w, http.StatusNotFound, err.Error())
+			return nil, false
+		}
+
+		a.internalError(w, "workflows/find", err)
+		return nil, false
+	}
+
+	return workflow, true
+}
+
+func (a *APIService) internalError(w http.ResponseWriter, svc string, err error) {
+	a.logger.Error("error processing api request", "svc", svc, "err", err)
+	writeError(w, http.StatusInternalServerError, "there was an error processing your request")
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body is too large")
+			return false
+		}
+
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -7,47 +7,108 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
-	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
-	"sync"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/ferretcode/scavenger/internal/infrastructure"
 	"github.com/ferretcode/scavenger/pkg/types"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
-var (
-	sessionsMu sync.Mutex
-	sessions   = make(map[string]bool) // map[sessionToken]isAuthenticated
-)
+type contextKey string
+
+const sessionContextKey contextKey = "session"
 
 type AuthService struct {
 	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+
+	sessions SessionStore
 }
 
 type ApiKey struct {
-	Hash string `json:"hash"`
+	ID         bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	Hash       string        `json:"hash"`
+	Name       string        `json:"name"`
+	Prefix     string        `json:"prefix"`
+	CreatedBy  string        `json:"created_by"`
+	CreatedAt  time.Time     `json:"created_at"`
+	ExpiresAt  *time.Time    `json:"expires_at"`
+	LastUsedAt *time.Time    `json:"last_used_at"`
+	RevokedAt  *time.Time    `json:"revoked_at"`
+	Scopes     ApiKeyScopes  `json:"scopes"`
+}
+
+// IsActive reports whether the key can still be used to authenticate
+func (k ApiKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+func (k ApiKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
 }
 
-func NewAuthService(config *types.ScavengerConfig) AuthService {
+type apiKeysPageData struct {
+	NewKey  string
+	Keys    []ApiKey
+	Actions []string
+	Now     time.Time
+}
+
+// the last used timestamp is only written once per interval per key
+const apiKeyLastUsedInterval = time.Minute
+
+// api key lifetimes offered when creating a key, in days. 0 never expires
+var apiKeyExpiryDays = map[string]int{
+	"never": 0,
+	"7":     7,
+	"30":    30,
+	"90":    90,
+	"365":   365,
+}
+
+func NewAuthService(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+	sessions SessionStore,
+) AuthService {
 	return AuthService{
-		Config: config,
+		Config:   config,
+		db:       db,
+		logger:   logger,
+		ctx:      ctx,
+		sessions: sessions,
 	}
 }
 
+func SessionFromContext(ctx context.Context) (Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(Session)
+	return session, ok
+}
+
 func (a *AuthService) RenderLogin(w http.ResponseWriter, r *http.Request, templates *template.Template) error {
-	cookie, err := r.Cookie(a.Config.SessionsCookieName)
-	if err == nil {
-		sessionsMu.Lock()
-		valid := sessions[cookie.Value]
-		sessionsMu.Unlock()
+	session, err := a.currentSession(w, r)
+	if err != nil {
+		return err
+	}
 
-		if valid {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return nil
-		}
+	if session != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return nil
 	}
 
 	return templates.ExecuteTemplate(w, "login.html", nil)
@@ -62,7 +123,12 @@ func (a *AuthService) Login(w http.ResponseWriter, r *http.Request) error {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
-	if username != a.Config.AdminUsername || password != a.Config.AdminPassword {
+	user, err := a.authenticate(username, password)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return nil
 	}
@@ -73,19 +139,26 @@ func (a *AuthService) Login(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	sessionsMu.Lock()
-	sessions[token] = true
-	sessionsMu.Unlock()
+	now := time.Now().UTC()
+	session := Session{
+		ID:         sessionID(token),
+		Username:   user.Username,
+		Role:       user.Role,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(a.sessionTTL()),
+		UserAgent:  r.UserAgent(),
+		RemoteAddr: r.RemoteAddr,
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     a.Config.SessionsCookieName,
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-	})
+	err = a.sessions.Create(session)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, a.sessionCookie(token, session.ExpiresAt))
 
-	fmt.Println("logged in")
+	a.logger.Info("user logged in", "username", user.Username)
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 
@@ -95,9 +168,10 @@ func (a *AuthService) Login(w http.ResponseWriter, r *http.Request) error {
 func (a *AuthService) Logout(w http.ResponseWriter, r *http.Request) error {
 	cookie, err := r.Cookie(a.Config.SessionsCookieName)
 	if err == nil {
-		sessionsMu.Lock()
-		delete(sessions, cookie.Value)
-		sessionsMu.Unlock()
+		err := a.sessions.Delete(sessionID(cookie.Value))
+		if err != nil {
+			return err
+		}
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -112,11 +186,72 @@ func (a *AuthService) Logout(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func (a *AuthService) RenderAPIKey(w http.ResponseWriter, r *http.Request, templates *template.Template, data any) error {
+func (a *AuthService) RenderAPIKey(w http.ResponseWriter, r *http.Request, templates *template.Template, newKey string) error {
+	opts := options.Find().SetSort(bson.D{{Key: "createdat", Value: -1}})
+
+	cur, err := a.db.Database(a.Config.DatabaseName).Collection("api_keys").Find(a.ctx, bson.D{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(a.ctx)
+
+	keys := []ApiKey{}
+	if err := cur.All(a.ctx, &keys); err != nil {
+		return err
+	}
+
+	data := apiKeysPageData{
+		NewKey:  newKey,
+		Keys:    keys,
+		Actions: ScopeActions,
+		Now:     time.Now().UTC(),
+	}
+
 	return templates.ExecuteTemplate(w, "api.html", data)
 }
 
 func (a *AuthService) CreateAPIKey(w http.ResponseWriter, r *http.Request, db *mongo.Client, templates *template.Template, ctx context.Context) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		http.Error(w, "a name is required for the api key", http.StatusBadRequest)
+		return nil
+	}
+
+	expiry := r.PostForm.Get("expiry")
+	if expiry == "" {
+		expiry = "never"
+	}
+
+	expiryDays, ok := apiKeyExpiryDays[expiry]
+	if !ok {
+		http.Error(w, "invalid expiry", http.StatusBadRequest)
+		return nil
+	}
+
+	actions := r.PostForm["scopeActions"]
+	if len(actions) == 0 {
+		http.Error(w, "select at least one action for the api key", http.StatusBadRequest)
+		return nil
+	}
+
+	for _, action := range actions {
+		if !ValidScopeAction(action) {
+			http.Error(w, "invalid action "+action, http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	scopes := ApiKeyScopes{
+		Workflows: parseWorkflowNames(r.PostForm.Get("scopeWorkflows")),
+		Tags:      infrastructure.ParseTags(r.PostForm.Get("scopeTags")),
+		Actions:   actions,
+	}
+
 	t, err := generateToken()
 	if err != nil {
 		return err
@@ -125,8 +260,23 @@ func (a *AuthService) CreateAPIKey(w http.ResponseWriter, r *http.Request, db *m
 	b := hashToken(t)
 	encoded := base64.StdEncoding.EncodeToString(b)
 
+	now := time.Now().UTC()
+
 	apiKey := ApiKey{
-		Hash: encoded,
+		Hash:      encoded,
+		Name:      name,
+		Prefix:    t[:8],
+		CreatedAt: now,
+		Scopes:    scopes,
+	}
+
+	if session, ok := SessionFromContext(r.Context()); ok {
+		apiKey.CreatedBy = session.Username
+	}
+
+	if expiryDays > 0 {
+		expiresAt := now.AddDate(0, 0, expiryDays)
+		apiKey.ExpiresAt = &expiresAt
 	}
 
 	_, err = db.Database(a.Config.DatabaseName).Collection("api_keys").InsertOne(ctx, apiKey)
@@ -137,6 +287,57 @@ func (a *AuthService) CreateAPIKey(w http.ResponseWriter, r *http.Request, db *m
 	return a.RenderAPIKey(w, r, templates, t)
 }
 
+func (a *AuthService) RevokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	id, err := bson.ObjectIDFromHex(r.PostForm.Get("keyId"))
+	if err != nil {
+		http.Error(w, "invalid api key id", http.StatusBadRequest)
+		return nil
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedat", Value: time.Now().UTC()}}}}
+
+	res, err := a.db.Database(a.Config.DatabaseName).Collection("api_keys").UpdateOne(a.ctx, bson.D{{Key: "_id", Value: id}, {Key: "revokedat", Value: nil}}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount > 0 {
+		a.logger.Info("api key revoked", "key-id", id.Hex())
+	}
+
+	http.Redirect(w, r, "/auth/api", http.StatusSeeOther)
+
+	return nil
+}
+
+// EnsureAPIKeys creates the index used to look up api keys by hash
+func (a *AuthService) EnsureAPIKeys() error {
+	_, err := a.db.Database(a.Config.DatabaseName).Collection("api_keys").Indexes().CreateOne(a.ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "hash", Value: 1}},
+	})
+
+	return err
+}
+
+// parseWorkflowNames splits a comma separated list of workflow names
+func parseWorkflowNames(names string) []string {
+	workflowNames := []string{}
+
+	for _, name := range strings.Split(names, ",") {
+		name = infrastructure.NormalizeWorkflowName(name)
+		if name != "" && !slices.Contains(workflowNames, name) {
+			workflowNames = append(workflowNames, name)
+		}
+	}
+
+	return workflowNames
+}
+
 // Generates a plaintext token
 func generateToken() (string, error) {
 	b := make([]byte, 32)
@@ -165,14 +366,16 @@ func (a *AuthService) RequireAPIKey(ctx context.Context, db *mongo.Client, logge
 					return
 				}
 
-				next.ServeHTTP(w, r)
+				// the headless key is not scoped
+				ctx := ContextWithAPIKey(r.Context(), ApiKey{Name: "headless"})
+				next.ServeHTTP(w, r.WithContext(ctx))
 
 				return
 			}
 
 			hash := hashToken(apiKey)
 			encoded := base64.StdEncoding.EncodeToString(hash)
-			filter := bson.D{{"hash", encoded}}
+			filter := bson.D{{Key: "hash", Value: encoded}}
 
 			res := db.Database(a.Config.DatabaseName).Collection("api_keys").FindOne(ctx, filter)
 
@@ -209,28 +412,69 @@ func (a *AuthService) RequireAPIKey(ctx context.Context, db *mongo.Client, logge
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			now := time.Now().UTC()
+
+			if key.RevokedAt != nil {
+				http.Error(w, "api key has been revoked", http.StatusUnauthorized)
+				return
+			}
+
+			if key.IsExpired(now) {
+				http.Error(w, "api key has expired", http.StatusUnauthorized)
+				return
+			}
+
+			if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyLastUsedInterval {
+				update := bson.D{{Key: "$set", Value: bson.D{{Key: "lastusedat", Value: now}}}}
+
+				_, err := db.Database(a.Config.DatabaseName).Collection("api_keys").UpdateByID(ctx, key.ID, update)
+				if err != nil {
+					logger.Warn("error updating api key last used time", "key-id", key.ID.Hex(), "err", err)
+				}
+			}
+
+			ctx := ContextWithAPIKey(r.Context(), key)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
 func (a *AuthService) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie(a.Config.SessionsCookieName)
+		session, err := a.currentSession(w, r)
 		if err != nil {
-			http.Redirect(w, r, "/auth/login", http.StatusFound)
+			a.logger.Error("error loading session", "err", err)
+			http.Error(w, "error authenticating your request", http.StatusInternalServerError)
 			return
 		}
 
-		sessionsMu.Lock()
-		valid := sessions[cookie.Value]
-		sessionsMu.Unlock()
-
-		if !valid {
+		if session == nil {
 			http.Redirect(w, r, "/auth/login", http.StatusFound)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), sessionContextKey, *session)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// RequireRole must run after RequireAuth. it rejects users whose role
+// ranks below the required one
+func (a *AuthService) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, ok := SessionFromContext(r.Context())
+			if !ok {
+				http.Redirect(w, r, "/auth/login", http.StatusFound)
+				return
+			}
+
+			if !HasRole(session.Role, role) {
+				http.Error(w, "you do not have permission to do this", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/go-chi/chi/v5"
+)
+
+const apiKeyContextKey contextKey = "api-key"
+
+const (
+	ScopeSubscribe   = "subscribe"
+	ScopeReadHistory = "read-history"
+	ScopeManage      = "manage"
+)
+
+var ScopeActions = []string{ScopeSubscribe, ScopeReadHistory, ScopeManage}
+
+// ApiKeyScopes restricts what an api key may do. an empty list leaves that
+// part of the scope unrestricted, which is how keys created before scopes
+// existed keep working
+type ApiKeyScopes struct {
+	Workflows []string `json:"workflows"`
+	Tags      []string `json:"tags"`
+	Actions   []string `json:"actions"`
+}
+
+func ValidScopeAction(action string) bool {
+	return slices.Contains(ScopeActions, action)
+}
+
+func (s ApiKeyScopes) AllowsAction(action string) bool {
+	return len(s.Actions) == 0 || slices.Contains(s.Actions, action)
+}
+
+// AllowsAllWorkflows reports whether the key is not restricted to
+// particular workflows or tags
+func (s ApiKeyScopes) AllowsAllWorkflows() bool {
+	return len(s.Workflows) == 0 && len(s.Tags) == 0
+}
+
+// AllowsWorkflow reports whether a workflow is named by the scope or
+// carries one of its tags
+func (s ApiKeyScopes) AllowsWorkflow(workflowName string, tags []string) bool {
+	if s.AllowsAllWorkflows() || slices.Contains(s.Workflows, workflowName) {
+		return true
+	}
+
+	for _, tag := range tags {
+		if slices.Contains(s.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func APIKeyFromContext(ctx context.Context) (ApiKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(ApiKey)
+	return key, ok
+}
+
+// ContextWithAPIKey returns a context carrying the api key a request was
+// authenticated with
+func ContextWithAPIKey(ctx context.Context, key ApiKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, key)
+}
+
+// RequireScope must run after RequireAPIKey. it rejects keys that are not
+// allowed to perform the action or, when the route has a workflow_name
+// param, to access that workflow. an empty action only checks the workflow
+func (a *AuthService) RequireScope(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := APIKeyFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if action != "" && !key.Scopes.AllowsAction(action) {
+				http.Error(w, "this api key is not allowed to "+action, http.StatusForbidden)
+				return
+			}
+
+			workflowName := chi.URLParam(r, "workflow_name")
+
+			allowed, err := a.keyAllowsWorkflow(key, workflowName)
+			if err != nil {
+				a.logger.Error("error checking api key scope", "key-id", key.ID.Hex(), "err", err)
+				http.Error(w, "error authenticating your request", http.StatusInternalServerError)
+				return
+			}
+
+			if !allowed {
+				http.Error(w, "this api key does not have access to this workflow", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// keyAllowsWorkflow only loads the workflow when the key is scoped by tags
+func (a *AuthService) keyAllowsWorkflow(key ApiKey, workflowName string) (bool, error) {
+	if workflowName == "" || key.Scopes.AllowsWorkflow(workflowName, nil) {
+		return true, nil
+	}
+
+	if len(key.Scopes.Tags) == 0 {
+		return false, nil
+	}
+
+	workflow, err := infrastructure.FindWorkflow(a.ctx, a.db, a.Config.DatabaseName, workflowName)
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return key.Scopes.AllowsWorkflow(workflow.Name, workflow.Tags), nil
+}
@@ -0,0 +1,378 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	defaultSessionTTL = 24 * time.Hour
+	// sessions are only extended once they have been idle this long, so
+	// every request does not have to write to the store
+	sessionTouchInterval = time.Minute
+)
+
+// Session is the logged in user attached to a request by RequireAuth.
+// the id is a hash of the cookie token so a leaked store cannot be replayed
+type Session struct {
+	ID         string    `bson:"_id" json:"id"`
+	Username   string    `bson:"username" json:"username"`
+	Role       string    `bson:"role" json:"role"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+	UserAgent  string    `bson:"user_agent" json:"user_agent"`
+	RemoteAddr string    `bson:"remote_addr" json:"remote_addr"`
+}
+
+type SessionStore interface {
+	Create(session Session) error
+	// Get returns nil if the session does not exist or has expired
+	Get(id string) (*Session, error)
+	Touch(id string, lastSeenAt time.Time, expiresAt time.Time) error
+	Delete(id string) error
+	DeleteForUser(username string) error
+	UpdateRoleForUser(username string, role string) error
+	List() ([]Session, error)
+}
+
+type sessionsPageData struct {
+	Sessions         []Session
+	CurrentSessionID string
+}
+
+// NewSessionStore picks the session store from SESSION_STORE. sessions are
+// kept in mongo unless "memory" is configured
+func NewSessionStore(kind string, db *mongo.Client, databaseName string, ctx context.Context) (SessionStore, error) {
+	if strings.ToLower(kind) == "memory" {
+		return NewMemorySessionStore(), nil
+	}
+
+	return NewMongoSessionStore(db, databaseName, ctx)
+}
+
+func sessionID(token string) string {
+	return hex.EncodeToString(hashToken(token))
+}
+
+func (a *AuthService) sessionTTL() time.Duration {
+	if a.Config.SessionTTL <= 0 {
+		return defaultSessionTTL
+	}
+
+	return a.Config.SessionTTL
+}
+
+func (a *AuthService) sessionCookie(token string, expiresAt time.Time) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+
+	switch strings.ToLower(a.Config.SessionCookieSameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     a.Config.SessionsCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   a.Config.SessionCookieSecure,
+		SameSite: sameSite,
+	}
+}
+
+// currentSession looks up the session for the request cookie and slides
+// its expiry forward
+func (a *AuthService) currentSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(a.Config.SessionsCookieName)
+	if err != nil {
+		return nil, nil
+	}
+
+	session, err := a.sessions.Get(sessionID(cookie.Value))
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return session, nil
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(a.sessionTTL())
+
+	err = a.sessions.Touch(session.ID, session.LastSeenAt, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, a.sessionCookie(cookie.Value, session.ExpiresAt))
+
+	return session, nil
+}
+
+func (a *AuthService) RenderSessions(w http.ResponseWriter, r *http.Request, templates *template.Template) error {
+	sessions, err := a.sessions.List()
+	if err != nil {
+		return err
+	}
+
+	data := sessionsPageData{
+		Sessions: sessions,
+	}
+
+	if session, ok := SessionFromContext(r.Context()); ok {
+		data.CurrentSessionID = session.ID
+	}
+
+	return templates.ExecuteTemplate(w, "sessions.html", data)
+}
+
+func (a *AuthService) RevokeSession(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	id := r.PostForm.Get("sessionId")
+	if id == "" {
+		http.Error(w, "sessionId is required", http.StatusBadRequest)
+		return nil
+	}
+
+	err := a.sessions.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("session revoked", "session-id", id)
+
+	http.Redirect(w, r, "/auth/sessions", http.StatusSeeOther)
+
+	return nil
+}
+
+type MongoSessionStore struct {
+	collection *mongo.Collection
+	ctx        context.Context
+}
+
+func NewMongoSessionStore(db *mongo.Client, databaseName string, ctx context.Context) (*MongoSessionStore, error) {
+	collection := db.Database(databaseName).Collection("sessions")
+
+	// mongo removes sessions once expires_at has passed
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys: bson.D{{Key: "username", Value: 1}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoSessionStore{
+		collection: collection,
+		ctx:        ctx,
+	}, nil
+}
+
+func (m *MongoSessionStore) Create(session Session) error {
+	_, err := m.collection.InsertOne(m.ctx, session)
+	return err
+}
+
+func (m *MongoSessionStore) Get(id string) (*Session, error) {
+	// the ttl monitor only runs once a minute, so check expiry here too
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now().UTC()}}},
+	}
+
+	res := m.collection.FindOne(m.ctx, filter)
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, res.Err()
+	}
+
+	session := Session{}
+	if err := res.Decode(&session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (m *MongoSessionStore) Touch(id string, lastSeenAt time.Time, expiresAt time.Time) error {
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "last_seen_at", Value: lastSeenAt},
+		{Key: "expires_at", Value: expiresAt},
+	}}}
+
+	_, err := m.collection.UpdateByID(m.ctx, id, update)
+	return err
+}
+
+func (m *MongoSessionStore) Delete(id string) error {
+	_, err := m.collection.DeleteOne(m.ctx, bson.D{{Key: "_id", Value: id}})
+	return err
+}
+
+func (m *MongoSessionStore) DeleteForUser(username string) error {
+	_, err := m.collection.DeleteMany(m.ctx, bson.D{{Key: "username", Value: username}})
+	return err
+}
+
+func (m *MongoSessionStore) UpdateRoleForUser(username string, role string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}}
+
+	_, err := m.collection.UpdateMany(m.ctx, bson.D{{Key: "username", Value: username}}, update)
+	return err
+}
+
+func (m *MongoSessionStore) List() ([]Session, error) {
+	filter := bson.D{{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now().UTC()}}}}
+
+	cur, err := m.collection.Find(m.ctx, filter, options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(m.ctx)
+
+	sessions := []Session{}
+	if err := cur.All(m.ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// MemorySessionStore keeps sessions in process. sessions are lost on
+// restart and are not shared between replicas
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session // map[sessionID]session
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]Session),
+	}
+}
+
+func (m *MemorySessionStore) Create(session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[session.ID] = session
+
+	return nil
+}
+
+func (m *MemorySessionStore) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+
+	return &session, nil
+}
+
+func (m *MemorySessionStore) Touch(id string, lastSeenAt time.Time, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = lastSeenAt
+		session.ExpiresAt = expiresAt
+		m.sessions[id] = session
+	}
+
+	return nil
+}
+
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+func (m *MemorySessionStore) DeleteForUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.Username == username {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemorySessionStore) UpdateRoleForUser(username string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.Username == username {
+			session.Role = role
+			m.sessions[id] = session
+		}
+	}
+
+	return nil
+}
+
+func (m *MemorySessionStore) List() ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sessions := []Session{}
+
+	for id, session := range m.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(m.sessions, id)
+			continue
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
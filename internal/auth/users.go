@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// each role can do everything the roles ranked below it can
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+const minPasswordLength = 8
+
+var errUserNotFound = errors.New("user does not exist")
+
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type usersPageData struct {
+	Users       []User
+	Roles       []string
+	CurrentUser string
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether a user with the given role may act as required
+func HasRole(role string, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+func (a *AuthService) users() *mongo.Collection {
+	return a.db.Database(a.Config.DatabaseName).Collection("users")
+}
+
+// EnsureUsers creates the users index and seeds an admin account from
+// ADMIN_USERNAME and ADMIN_PASSWORD when there are no users yet
+func (a *AuthService) EnsureUsers() error {
+	_, err := a.users().Indexes().CreateOne(a.ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	count, err := a.users().CountDocuments(a.ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	if a.Config.AdminUsername == "" || a.Config.AdminPassword == "" {
+		a.logger.Warn("no users exist and no admin credentials are configured, nobody will be able to log in")
+		return nil
+	}
+
+	a.logger.Info("no users exist, creating admin user from configuration", "username", a.Config.AdminUsername)
+
+	return a.createUser(a.Config.AdminUsername, a.Config.AdminPassword, RoleAdmin)
+}
+
+func (a *AuthService) RenderUsers(w http.ResponseWriter, r *http.Request, templates *template.Template) error {
+	cur, err := a.users().Find(a.ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "username", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(a.ctx)
+
+	users := []User{}
+	if err := cur.All(a.ctx, &users); err != nil {
+		return err
+	}
+
+	data := usersPageData{
+		Users: users,
+		Roles: []string{RoleAdmin, RoleEditor, RoleViewer},
+	}
+
+	if session, ok := SessionFromContext(r.Context()); ok {
+		data.CurrentUser = session.Username
+	}
+
+	return templates.ExecuteTemplate(w, "users.html", data)
+}
+
+func (a *AuthService) CreateUser(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	password := r.PostForm.Get("password")
+	role := r.PostForm.Get("role")
+
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return nil
+	}
+
+	if len(password) < minPasswordLength {
+		http.Error(w, "password must be at least 8 characters", http.StatusBadRequest)
+		return nil
+	}
+
+	if !ValidRole(role) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return nil
+	}
+
+	err := a.createUser(username, password, role)
+	if mongo.IsDuplicateKeyError(err) {
+		http.Error(w, "a user with this username already exists", http.StatusConflict)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/auth/users", http.StatusSeeOther)
+
+	return nil
+}
+
+func (a *AuthService) UpdateUserRole(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	username := r.PostForm.Get("username")
+	role := r.PostForm.Get("role")
+
+	if !ValidRole(role) {
+		http.Error(w, "invalid role", http.StatusBadRequest)
+		return nil
+	}
+
+	user, err := a.findUser(username)
+	if err == errUserNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Role == RoleAdmin && role != RoleAdmin {
+		ok, err := a.hasOtherAdmin(username)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			http.Error(w, "the last admin cannot be demoted", http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	_, err = a.users().UpdateOne(a.ctx, bson.D{{Key: "username", Value: username}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}})
+	if err != nil {
+		return err
+	}
+
+	err = a.sessions.UpdateRoleForUser(username, role)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/auth/users", http.StatusSeeOther)
+
+	return nil
+}
+
+func (a *AuthService) DeleteUser(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	username := r.PostForm.Get("username")
+
+	if session, ok := SessionFromContext(r.Context()); ok && session.Username == username {
+		http.Error(w, "you cannot delete your own user", http.StatusBadRequest)
+		return nil
+	}
+
+	user, err := a.findUser(username)
+	if err == errUserNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if user.Role == RoleAdmin {
+		ok, err := a.hasOtherAdmin(username)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			http.Error(w, "the last admin cannot be deleted", http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	_, err = a.users().DeleteOne(a.ctx, bson.D{{Key: "username", Value: username}})
+	if err != nil {
+		return err
+	}
+
+	err = a.sessions.DeleteForUser(username)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/auth/users", http.StatusSeeOther)
+
+	return nil
+}
+
+func (a *AuthService) createUser(username string, password string, role string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.users().InsertOne(a.ctx, User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         role,
+		CreatedAt:    time.Now().UTC(),
+	})
+
+	return err
+}
+
+func (a *AuthService) findUser(username string) (*User, error) {
+	res := a.users().FindOne(a.ctx, bson.D{{Key: "username", Value: username}})
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return nil, errUserNotFound
+		}
+		return nil, res.Err()
+	}
+
+	user := User{}
+	if err := res.Decode(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// authenticate checks the password against the stored hash. unknown users
+// still pay for a hash comparison so the response time does not leak them
+func (a *AuthService) authenticate(username string, password string) (*User, error) {
+	user, err := a.findUser(username)
+	if err != nil && err != errUserNotFound {
+		return nil, err
+	}
+
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, nil
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+func (a *AuthService) hasOtherAdmin(username string) (bool, error) {
+	count, err := a.users().CountDocuments(a.ctx, bson.D{
+		{Key: "role", Value: RoleAdmin},
+		{Key: "username", Value: bson.D{{Key: "$ne", Value: username}}},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("scavenger-dummy-password"), bcrypt.DefaultCost)
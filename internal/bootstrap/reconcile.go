@@ -0,0 +1,395 @@
+package bootstrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	// a deployment without a database record, it is deleted and the
+	// workflow created again
+	ActionRecreate = "recreate"
+	// a workflow without a deployment, it is deployed again from its
+	// record so it keeps its status, run token and counters
+	ActionRedeploy = "redeploy"
+	// a workflow created in the ui or the api that is now in the config
+	// file, only its source is recorded
+	ActionAdopt     = "adopt"
+	ActionDelete    = "delete"
+	ActionUnchanged = "unchanged"
+)
+
+// Step is what the reconciler does to converge a single workflow
+type Step struct {
+	Action string
+	Name   string
+	// Changes lists the fields that differ for updates, and why the
+	// workflow is recreated or deleted otherwise
+	Changes []string
+
+	workflow infrastructure.Workflow
+}
+
+// Plan is the difference between the workflows in the config file and the
+// deployed ones. Invalid holds the config entries that could not be read,
+// their workflows are left as they are
+type Plan struct {
+	Steps   []Step
+	Invalid []error
+}
+
+// Pending returns the steps that change something
+func (p Plan) Pending() []Step {
+	pending := []Step{}
+	for _, step := range p.Steps {
+		if step.Action != ActionUnchanged {
+			pending = append(pending, step)
+		}
+	}
+	return pending
+}
+
+func (s Step) String() string {
+	if len(s.Changes) == 0 {
+		return s.Action + " " + s.Name
+	}
+	return fmt.Sprintf("%s %s: %s", s.Action, s.Name, strings.Join(s.Changes, ", "))
+}
+
+func (p Plan) count(action string) int {
+	n := 0
+	for _, step := range p.Steps {
+		if step.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Reconciler converges the deployed workflows to the ones in the config
+// file. only workflows created from the config file are deleted when they
+// are removed from it
+type Reconciler struct {
+	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+
+	serviceProvider infrastructure.ServiceProvider
+
+	// serializes runs started at startup, by file changes and by signals
+	mu sync.Mutex
+
+	statusMu sync.Mutex
+	status   *ReconcileStatus
+}
+
+func NewReconciler(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+	serviceProvider infrastructure.ServiceProvider,
+) *Reconciler {
+	return &Reconciler{
+		Config:          config,
+		db:              db,
+		logger:          logger,
+		ctx:             ctx,
+		serviceProvider: serviceProvider,
+	}
+}
+
+// Reconcile plans the changes, logs the plan and applies it unless dry runs
+// are enabled. errors of invalid config entries are returned with the
+// errors of the applied steps. trigger says what started the run and is
+// shown with its outcome on the dashboard
+func (r *Reconciler) Reconcile(trigger string) (Plan, []error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := ReconcileStatus{
+		Trigger: trigger,
+		At:      time.Now().UTC(),
+		DryRun:  r.Config.ReconcileDryRun,
+	}
+
+	plan, err := r.Plan()
+	if errors.Is(err, fs.ErrNotExist) {
+		// without a config file there is nothing to converge to, and a
+		// removed file should not delete every workflow it created
+		r.logger.Warn("no workflow configuration found, skipping reconcile", "path", r.Config.ConfigPath)
+		return plan, nil
+	}
+	if err != nil {
+		status.Errors = []string{err.Error()}
+		r.setStatus(status)
+		return plan, []error{err}
+	}
+
+	r.logPlan(plan)
+
+	errs := slices.Clone(plan.Invalid)
+
+	if r.Config.ReconcileDryRun {
+		r.logger.Info("reconcile dry run enabled, not applying the plan")
+
+		for _, step := range plan.Pending() {
+			status.Planned = append(status.Planned, step.String())
+		}
+	} else {
+		applied, applyErrs := r.Apply(plan)
+		errs = append(errs, applyErrs...)
+
+		for _, step := range applied {
+			status.Applied = append(status.Applied, step.String())
+		}
+	}
+
+	for _, err := range errs {
+		status.Errors = append(status.Errors, err.Error())
+	}
+
+	r.setStatus(status)
+
+	return plan, errs
+}
+
+// Plan diffs the workflows in the config file against the database and
+// the service provider without changing anything
+func (r *Reconciler) Plan() (Plan, error) {
+	specs, err := LoadConfig(r.Config.ConfigPath)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to load workflow configuration: %w", err)
+	}
+
+	current, err := infrastructure.ListWorkflows(r.ctx, r.db, r.Config.DatabaseName)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	// listed once, on gcp every lookup lists all cloud run services
+	deployed, err := r.serviceProvider.DeployedWorkflows()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	currentByName := make(map[string]infrastructure.Workflow)
+	for _, workflow := range current {
+		currentByName[workflow.Name] = workflow
+	}
+
+	plan := Plan{}
+
+	// names in the config file, including invalid entries, so a typo in
+	// the file does not delete the workflow
+	configured := make(map[string]bool)
+
+	for _, spec := range specs {
+		name := infrastructure.NormalizeWorkflowName(spec.Name)
+
+		if name != "" && configured[name] {
+			plan.Invalid = append(plan.Invalid, fmt.Errorf("workflow %s is defined more than once", name))
+			continue
+		}
+		configured[name] = true
+
+		workflow, err := infrastructure.WorkflowFromConfig(spec)
+		if err != nil {
+			if errors.Is(err, infrastructure.ErrEmptySchema) {
+				r.logger.Warn("no schema fields found for workflow, skipping", "workflow-name", name)
+				continue
+			}
+
+			plan.Invalid = append(plan.Invalid, err)
+			continue
+		}
+
+		workflow.Source = infrastructure.WorkflowSourceConfig
+
+		existing, stored := currentByName[workflow.Name]
+
+		step := Step{Name: workflow.Name, workflow: workflow}
+
+		switch {
+		case !stored && !deployed[workflow.Name]:
+			step.Action = ActionCreate
+		case !stored:
+			step.Action = ActionRecreate
+			step.Changes = []string{"the deployment has no database record"}
+		case !deployed[workflow.Name]:
+			step.Action = ActionRedeploy
+			step.Changes = []string{"the workflow has no deployment"}
+		default:
+			step.Changes = diffWorkflows(existing, workflow)
+
+			switch {
+			case len(step.Changes) == 0:
+				step.Action = ActionUnchanged
+			case slices.Equal(step.Changes, []string{"source"}):
+				step.Action = ActionAdopt
+			default:
+				step.Action = ActionUpdate
+			}
+		}
+
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	for _, workflow := range current {
+		if workflow.Source != infrastructure.WorkflowSourceConfig || configured[workflow.Name] {
+			continue
+		}
+
+		plan.Steps = append(plan.Steps, Step{
+			Action:  ActionDelete,
+			Name:    workflow.Name,
+			Changes: []string{"removed from the config file"},
+		})
+	}
+
+	return plan, nil
+}
+
+// Apply runs the pending steps of a plan and returns the ones that
+// succeeded. a failed step does not stop the others
+func (r *Reconciler) Apply(plan Plan) ([]Step, []error) {
+	applied := []Step{}
+	var errs []error
+
+	for _, step := range plan.Pending() {
+		err := r.apply(step)
+		if err != nil {
+			r.logger.Error("failed to reconcile workflow", "action", step.Action, "workflow-name", step.Name, "err", err)
+			errs = append(errs, fmt.Errorf("%s workflow %s: %w", step.Action, step.Name, err))
+			continue
+		}
+
+		r.logger.Info("reconciled workflow", "action", step.Action, "workflow-name", step.Name)
+		applied = append(applied, step)
+	}
+
+	return applied, errs
+}
+
+func (r *Reconciler) apply(step Step) error {
+	switch step.Action {
+	case ActionCreate:
+		err := r.serviceProvider.CreateWorkflowFromConfig(step.workflow)
+		if err != nil {
+			return err
+		}
+
+		return r.checkStored(step.Name)
+	case ActionUpdate:
+		return r.serviceProvider.UpdateWorkflowFromConfig(step.workflow)
+	case ActionRecreate:
+		// deleting by name removes every deployment labelled with the
+		// workflow, including stopped ones without a database record
+		err := r.serviceProvider.DeleteWorkflowByName(step.Name)
+		if err != nil && !errors.Is(err, infrastructure.ErrNoWorkflowExists) {
+			return err
+		}
+
+		err = r.serviceProvider.CreateWorkflowFromConfig(step.workflow)
+		if err != nil {
+			return err
+		}
+
+		return r.checkStored(step.Name)
+	case ActionRedeploy:
+		// updating a workflow without a deployment deploys it again
+		return r.serviceProvider.UpdateWorkflowFromConfig(step.workflow)
+	case ActionAdopt:
+		return infrastructure.SetWorkflowSource(r.ctx, r.db, r.Config.DatabaseName, step.Name, infrastructure.WorkflowSourceConfig)
+	case ActionDelete:
+		return r.serviceProvider.DeleteWorkflowByName(step.Name)
+	default:
+		return nil
+	}
+}
+
+// checkStored makes sure a created workflow has a database record. a
+// provider that finds the workflow already deployed skips the creation,
+// without a record the same step would be planned on every run
+func (r *Reconciler) checkStored(workflowName string) error {
+	_, err := infrastructure.FindWorkflow(r.ctx, r.db, r.Config.DatabaseName, workflowName)
+	if errors.Is(err, infrastructure.ErrNoWorkflowExists) {
+		return fmt.Errorf("workflow %s has no database record after it was created", workflowName)
+	}
+
+	return err
+}
+
+func (r *Reconciler) logPlan(plan Plan) {
+	r.logger.Info(
+		"reconcile plan",
+		"create", plan.count(ActionCreate),
+		"update", plan.count(ActionUpdate),
+		"recreate", plan.count(ActionRecreate),
+		"redeploy", plan.count(ActionRedeploy),
+		"adopt", plan.count(ActionAdopt),
+		"delete", plan.count(ActionDelete),
+		"unchanged", plan.count(ActionUnchanged),
+		"invalid", len(plan.Invalid),
+		"dry-run", r.Config.ReconcileDryRun,
+	)
+
+	for _, step := range plan.Pending() {
+		r.logger.Info("plan", "action", step.Action, "workflow-name", step.Name, "changes", strings.Join(step.Changes, ", "))
+	}
+
+	for _, err := range plan.Invalid {
+		r.logger.Error("invalid workflow in configuration", "err", err)
+	}
+}
+
+// diffWorkflows returns the spec fields that differ between a stored
+// workflow and the one built from the config file. fields are compared in
+// their config form so empty and missing values are equal
+func diffWorkflows(current infrastructure.Workflow, desired infrastructure.Workflow) []string {
+	currentSpec := infrastructure.ConfigFromWorkflow(current)
+	desiredSpec := infrastructure.ConfigFromWorkflow(desired)
+
+	fields := []struct {
+		name             string
+		current, desired any
+	}{
+		{"prompt", currentSpec.Prompt, desiredSpec.Prompt},
+		{"cron", currentSpec.Cron, desiredSpec.Cron},
+		{"website", currentSpec.Website, desiredSpec.Website},
+		{"tags", infrastructure.NormalizeTags(currentSpec.Tags), desiredSpec.Tags},
+		{"change_detection", currentSpec.ChangeDetection, desiredSpec.ChangeDetection},
+		{"drop_invalid", currentSpec.DropInvalid, desiredSpec.DropInvalid},
+		{"schema", currentSpec.Schema, desiredSpec.Schema},
+		{"source", current.Source, desired.Source},
+	}
+
+	changes := []string{}
+
+	for _, field := range fields {
+		currentJSON, _ := json.Marshal(field.current)
+		desiredJSON, _ := json.Marshal(field.desired)
+
+		if !bytes.Equal(currentJSON, desiredJSON) {
+			changes = append(changes, field.name)
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,106 @@
+package bootstrap
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	TriggerStartup    = "startup"
+	TriggerFileChange = "config file changed"
+	TriggerSignal     = "SIGHUP"
+)
+
+// ReconcileStatus is the outcome of the last reconcile run, shown on the
+// dashboard
+type ReconcileStatus struct {
+	Trigger string
+	At      time.Time
+	DryRun  bool
+	// Applied lists the steps that succeeded, Planned the steps a dry run
+	// would have applied
+	Applied []string
+	Planned []string
+	Errors  []string
+}
+
+func (s ReconcileStatus) OK() bool {
+	return len(s.Errors) == 0
+}
+
+// Status returns the outcome of the last reconcile run, or nil if none ran
+func (r *Reconciler) Status() *ReconcileStatus {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	if r.status == nil {
+		return nil
+	}
+
+	status := *r.status
+	return &status
+}
+
+func (r *Reconciler) setStatus(status ReconcileStatus) {
+	r.statusMu.Lock()
+	r.status = &status
+	r.statusMu.Unlock()
+}
+
+// Watch reconciles again whenever the config file changes or the process
+// receives SIGHUP, until the reconciler context is cancelled. the file is
+// polled every interval
+func (r *Reconciler) Watch(interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastVersion, _ := r.configVersion()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-signals:
+			lastVersion, _ = r.configVersion()
+			r.reload(TriggerSignal)
+		case <-ticker.C:
+			version, ok := r.configVersion()
+			if !ok || version == lastVersion {
+				continue
+			}
+
+			lastVersion = version
+			r.reload(TriggerFileChange)
+		}
+	}
+}
+
+func (r *Reconciler) reload(trigger string) {
+	r.logger.Info("reloading workflow configuration", "trigger", trigger, "path", r.Config.ConfigPath)
+
+	_, errs := r.Reconcile(trigger)
+	if len(errs) > 0 {
+		r.logger.Error("workflow configuration reloaded with errors", "trigger", trigger, "errors", len(errs))
+		return
+	}
+
+	r.logger.Info("workflow configuration reloaded", "trigger", trigger)
+}
+
+// configVersion identifies the current contents of the config file by its
+// modification time and size. ok is false while the file cannot be read,
+// for example while an editor replaces it
+func (r *Reconciler) configVersion() (version [2]int64, ok bool) {
+	info, err := os.Stat(r.Config.ConfigPath)
+	if err != nil {
+		return version, false
+	}
+
+	return [2]int64{info.ModTime().UnixNano(), info.Size()}, true
+}
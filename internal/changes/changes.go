@@ -0,0 +1,159 @@
+package changes
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/ferretcode/scavenger/internal/jsonpointer"
+)
+
+const (
+	OpAdded   = "added"
+	OpRemoved = "removed"
+	OpChanged = "changed"
+)
+
+// maxDiffEntries caps the diff so a result that changed completely does
+// not produce a diff larger than the result itself
+const maxDiffEntries = 100
+
+// Diff is a single difference between two results. Path is a JSON pointer
+// into the compared value
+type Diff struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+type Change struct {
+	Changed bool
+	Diff    []Diff
+}
+
+// Detect compares a result with the previous one. when key fields are given
+// only those fields of the objects in each result are compared, otherwise the
+// whole payload is. payloads that are not json are compared as strings
+func Detect(keyFields []string, previous []byte, current []byte) Change {
+	var previousValue, currentValue any
+
+	if json.Unmarshal(previous, &previousValue) != nil || json.Unmarshal(current, &currentValue) != nil {
+		if bytes.Equal(previous, current) {
+			return Change{}
+		}
+
+		return Change{
+			Changed: true,
+			Diff:    []Diff{{Path: "", Op: OpChanged, Old: string(previous), New: string(current)}},
+		}
+	}
+
+	if len(keyFields) > 0 {
+		previousValue = project(previousValue, keyFields)
+		currentValue = project(currentValue, keyFields)
+	}
+
+	diffs := []Diff{}
+	diff("", previousValue, currentValue, &diffs)
+
+	return Change{
+		Changed: len(diffs) > 0,
+		Diff:    diffs,
+	}
+}
+
+// project keeps only the key fields of every object in the value. workers
+// usually return a list of objects so lists are projected element by element
+func project(value any, keyFields []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		projected := make(map[string]any)
+		for key, field := range v {
+			if slices.Contains(keyFields, key) {
+				projected[key] = field
+			}
+		}
+		return projected
+	case []any:
+		projected := make([]any, len(v))
+		for i, element := range v {
+			projected[i] = project(element, keyFields)
+		}
+		return projected
+	default:
+		return value
+	}
+}
+
+func diff(path string, previous any, current any, diffs *[]Diff) {
+	if len(*diffs) >= maxDiffEntries {
+		return
+	}
+
+	switch p := previous.(type) {
+	case map[string]any:
+		c, ok := current.(map[string]any)
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(p)+len(c))
+		for key := range p {
+			keys = append(keys, key)
+		}
+		for key := range c {
+			if _, ok := p[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			childPath := path + "/" + jsonpointer.Escape(key)
+			previousChild, inPrevious := p[key]
+			currentChild, inCurrent := c[key]
+
+			switch {
+			case !inPrevious:
+				appendDiff(diffs, Diff{Path: childPath, Op: OpAdded, New: currentChild})
+			case !inCurrent:
+				appendDiff(diffs, Diff{Path: childPath, Op: OpRemoved, Old: previousChild})
+			default:
+				diff(childPath, previousChild, currentChild, diffs)
+			}
+		}
+		return
+	case []any:
+		c, ok := current.([]any)
+		if !ok {
+			break
+		}
+
+		for i := 0; i < max(len(p), len(c)); i++ {
+			childPath := path + "/" + strconv.Itoa(i)
+
+			switch {
+			case i >= len(p):
+				appendDiff(diffs, Diff{Path: childPath, Op: OpAdded, New: c[i]})
+			case i >= len(c):
+				appendDiff(diffs, Diff{Path: childPath, Op: OpRemoved, Old: p[i]})
+			default:
+				diff(childPath, p[i], c[i], diffs)
+			}
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(previous, current) {
+		appendDiff(diffs, Diff{Path: path, Op: OpChanged, Old: previous, New: current})
+	}
+}
+
+func appendDiff(diffs *[]Diff, d Diff) {
+	if len(*diffs) < maxDiffEntries {
+		*diffs = append(*diffs, d)
+	}
+}
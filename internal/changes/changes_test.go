@@ -0,0 +1,159 @@
+package changes_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ferretcode/scavenger/internal/changes"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyFields []string
+		previous  string
+		current   string
+		want      changes.Change
+	}{
+		{
+			name:     "equal objects",
+			previous: `{"a": 1, "b": [1, 2]}`,
+			current:  `{"b": [1, 2], "a": 1}`,
+			want:     changes.Change{Diff: []changes.Diff{}},
+		},
+		{
+			name:     "changed field",
+			previous: `{"price": 10}`,
+			current:  `{"price": 12}`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "/price", Op: changes.OpChanged, Old: float64(10), New: float64(12)},
+			}},
+		},
+		{
+			name:     "added and removed fields are sorted by key",
+			previous: `{"b": 1, "c": 2}`,
+			current:  `{"a": 3, "c": 2}`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "/a", Op: changes.OpAdded, New: float64(3)},
+				{Path: "/b", Op: changes.OpRemoved, Old: float64(1)},
+			}},
+		},
+		{
+			name:     "list elements",
+			previous: `[{"name": "a"}, {"name": "b"}]`,
+			current:  `[{"name": "a"}, {"name": "c"}, {"name": "d"}]`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "/1/name", Op: changes.OpChanged, Old: "b", New: "c"},
+				{Path: "/2", Op: changes.OpAdded, New: map[string]any{"name": "d"}},
+			}},
+		},
+		{
+			name:     "keys are escaped in paths",
+			previous: `{"a/b": 1, "c~d": 1}`,
+			current:  `{"a/b": 2, "c~d": 2}`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "/a~1b", Op: changes.OpChanged, Old: float64(1), New: float64(2)},
+				{Path: "/c~0d", Op: changes.OpChanged, Old: float64(1), New: float64(2)},
+			}},
+		},
+		{
+			name:     "type change",
+			previous: `{"a": [1]}`,
+			current:  `{"a": {"0": 1}}`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "/a", Op: changes.OpChanged, Old: []any{float64(1)}, New: map[string]any{"0": float64(1)}},
+			}},
+		},
+		{
+			name:      "key fields ignore other fields",
+			keyFields: []string{"name"},
+			previous:  `[{"name": "a", "seen": 1}]`,
+			current:   `[{"name": "a", "seen": 2}]`,
+			want:      changes.Change{Diff: []changes.Diff{}},
+		},
+		{
+			name:      "key fields report their own changes",
+			keyFields: []string{"name"},
+			previous:  `[{"name": "a", "seen": 1}]`,
+			current:   `[{"name": "b", "seen": 2}]`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "/0/name", Op: changes.OpChanged, Old: "a", New: "b"},
+			}},
+		},
+		{
+			name:      "key fields leave scalars alone",
+			keyFields: []string{"name"},
+			previous:  `"a"`,
+			current:   `"b"`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "", Op: changes.OpChanged, Old: "a", New: "b"},
+			}},
+		},
+		{
+			name:     "equal non-json payloads",
+			previous: `not json`,
+			current:  `not json`,
+			want:     changes.Change{},
+		},
+		{
+			name:     "changed non-json payloads",
+			previous: `not json`,
+			current:  `{"a": 1}`,
+			want: changes.Change{Changed: true, Diff: []changes.Diff{
+				{Path: "", Op: changes.OpChanged, Old: "not json", New: `{"a": 1}`},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := changes.Detect(test.keyFields, []byte(test.previous), []byte(test.current))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Detect(%v, %s, %s) = %+v, want %+v", test.keyFields, test.previous, test.current, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetectCapsDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries int
+		want    int
+	}{
+		{name: "below the cap", entries: changes.MaxDiffEntries - 1, want: changes.MaxDiffEntries - 1},
+		{name: "at the cap", entries: changes.MaxDiffEntries, want: changes.MaxDiffEntries},
+		{name: "above the cap", entries: changes.MaxDiffEntries * 3, want: changes.MaxDiffEntries},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previous := make([]int, test.entries)
+			current := make([]int, test.entries)
+			for i := range test.entries {
+				previous[i] = i
+				current[i] = i + 1
+			}
+
+			previousJSON, _ := json.Marshal(previous)
+			currentJSON, _ := json.Marshal(current)
+
+			got := changes.Detect(nil, previousJSON, currentJSON)
+			if !got.Changed {
+				t.Errorf("Changed = false, want true")
+			}
+
+			if len(got.Diff) != test.want {
+				t.Fatalf("len(Diff) = %d, want %d", len(got.Diff), test.want)
+			}
+
+			// the first differences are kept, later ones are dropped
+			last := got.Diff[len(got.Diff)-1]
+			if want := fmt.Sprintf("/%d", test.want-1); last.Path != want {
+				t.Errorf("last diff path = %q, want %q", last.Path, want)
+			}
+		})
+	}
+}
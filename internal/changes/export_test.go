@@ -0,0 +1,4 @@
+package changes
+
+// MaxDiffEntries lets tests outside the package check the diff cap
+const MaxDiffEntries = maxDiffEntries
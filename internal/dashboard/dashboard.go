@@ -3,8 +3,8 @@ package dashboard
 import (
 	"context"
 
+	"github.com/ferretcode/scavenger/internal/bootstrap"
 	"github.com/ferretcode/scavenger/internal/infrastructure"
-	"github.com/ferretcode/scavenger/internal/workflow"
 )
 
 type TopDashData struct {
@@ -14,8 +14,9 @@ type TopDashData struct {
 }
 
 type DashboardData struct {
-	Workflows   []workflow.Workflow
+	Workflows   []infrastructure.Workflow
 	TopCardData TopDashData
+	Reconcile   *bootstrap.ReconcileStatus
 }
 
 func GetTopDashData(serviceProvider infrastructure.ServiceProvider, ctx context.Context) TopDashData {
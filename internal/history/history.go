@@ -0,0 +1,198 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/ferretcode/scavenger/pkg/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const collectionName = "history"
+
+type HistoryService struct {
+	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+}
+
+// Result is a single message received from a workflow worker
+type Result struct {
+	ID           bson.ObjectID   `bson:"_id,omitempty" json:"id"`
+	WorkflowName string          `bson:"workflow_name" json:"workflow_name"`
+	ScrapedAt    time.Time       `bson:"scraped_at" json:"scraped_at"`
+	Raw          string          `bson:"raw" json:"raw"`
+	Data         json.RawMessage `bson:"-" json:"data,omitempty"`
+	// Valid is only set for workflows with a schema to validate against
+	Valid            *bool    `bson:"valid,omitempty" json:"valid,omitempty"`
+	ValidationErrors []string `bson:"validation_errors,omitempty" json:"validation_errors,omitempty"`
+	// Changed is only set for workflows with change detection
+	Changed *bool `bson:"changed,omitempty" json:"changed,omitempty"`
+	// Dropped results were recorded but never sent to subscribers or
+	// webhooks, because they were invalid or unchanged
+	Dropped bool `bson:"dropped,omitempty" json:"dropped,omitempty"`
+}
+
+// the parsed payload is stored alongside the raw string so it can be
+// queried in mongo, but it is always served back from the raw string
+type resultDocument struct {
+	Result `bson:",inline"`
+	Parsed any `bson:"data,omitempty"`
+}
+
+func NewHistoryService(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+) HistoryService {
+	return HistoryService{
+		Config: config,
+		db:     db,
+		logger: logger,
+		ctx:    ctx,
+	}
+}
+
+func (h *HistoryService) collection() *mongo.Collection {
+	return h.db.Database(h.Config.DatabaseName).Collection(collectionName)
+}
+
+func (h *HistoryService) EnsureIndexes() error {
+	_, err := h.collection().Indexes().CreateOne(h.ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workflow_name", Value: 1}, {Key: "scraped_at", Value: -1}},
+	})
+
+	return err
+}
+
+// Record stores a result, setting its id and the time it was scraped
+func (h *HistoryService) Record(result Result) (*Result, error) {
+	result.ScrapedAt = time.Now().UTC()
+
+	document := resultDocument{
+		Result: result,
+	}
+
+	var parsed any
+	if err := json.Unmarshal([]byte(result.Raw), &parsed); err == nil {
+		document.Parsed = parsed
+	} else {
+		h.logger.Warn("worker payload is not valid json, storing raw payload only", "workflow-name", result.WorkflowName, "err", err)
+	}
+
+	res, err := h.collection().InsertOne(h.ctx, document)
+	if err != nil {
+		return nil, err
+	}
+
+	result.ID = res.InsertedID.(bson.ObjectID)
+	result.restoreData()
+
+	return &result, nil
+}
+
+// List returns the most recent results for a workflow, newest first
+func (h *HistoryService) List(workflowName string, limit int64) ([]Result, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "scraped_at", Value: -1}}).
+		SetLimit(limit)
+
+	return h.find(bson.D{{Key: "workflow_name", Value: workflowName}}, opts)
+}
+
+// ListDelivered returns the most recent results that were sent to
+// subscribers, newest first
+func (h *HistoryService) ListDelivered(workflowName string, limit int64) ([]Result, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "scraped_at", Value: -1}}).
+		SetLimit(limit)
+
+	return h.find(deliveredFilter(workflowName), opts)
+}
+
+// After returns the delivered results recorded after the given result,
+// oldest first
+func (h *HistoryService) After(workflowName string, after bson.ObjectID, limit int64) ([]Result, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	filter := append(deliveredFilter(workflowName), bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: after}}})
+
+	return h.find(filter, opts)
+}
+
+// Since returns the delivered results scraped at or after the given time,
+// oldest first
+func (h *HistoryService) Since(workflowName string, since time.Time, limit int64) ([]Result, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	filter := append(deliveredFilter(workflowName), bson.E{Key: "scraped_at", Value: bson.D{{Key: "$gte", Value: since}}})
+
+	return h.find(filter, opts)
+}
+
+// Latest returns the most recent result, including dropped ones
+func (h *HistoryService) Latest(workflowName string) (*Result, error) {
+	return first(h.List(workflowName, 1))
+}
+
+// LatestDelivered returns the most recent result that was sent to
+// subscribers
+func (h *HistoryService) LatestDelivered(workflowName string) (*Result, error) {
+	return first(h.ListDelivered(workflowName, 1))
+}
+
+func first(results []Result, err error) (*Result, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	return &results[0], nil
+}
+
+func deliveredFilter(workflowName string) bson.D {
+	return bson.D{
+		{Key: "workflow_name", Value: workflowName},
+		{Key: "dropped", Value: bson.D{{Key: "$ne", Value: true}}},
+	}
+}
+
+func (h *HistoryService) find(filter bson.D, opts *options.FindOptionsBuilder) ([]Result, error) {
+	cur, err := h.collection().Find(h.ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(h.ctx)
+
+	results := []Result{}
+	for cur.Next(h.ctx) {
+		var result Result
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		result.restoreData()
+		results = append(results, result)
+	}
+
+	return results, cur.Err()
+}
+
+func (r *Result) restoreData() {
+	if json.Valid([]byte(r.Raw)) {
+		r.Data = json.RawMessage(r.Raw)
+	}
+}
@@ -0,0 +1,175 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ferretcode/scavenger/pkg/types"
+)
+
+var ErrEmptySchema = errors.New("workflow schema has no fields")
+
+var workflowNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// NormalizeWorkflowName converts a user supplied name to the form
+// used for container labels and database lookups
+func NormalizeWorkflowName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+}
+
+// ParseTags splits a comma separated list of tags, as submitted by the
+// workflow form, and normalizes it
+func ParseTags(tags string) []string {
+	return NormalizeTags(strings.Split(tags, ","))
+}
+
+// NormalizeTags lowercases and trims tags, dropping empty and duplicate ones
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	return normalized
+}
+
+// ParseChangeDetection validates a change detection mode and its key fields.
+// an empty mode turns change detection off
+func ParseChangeDetection(mode string, keyFields []string) (ChangeDetection, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+
+	switch mode {
+	case "", ChangeDetectionOff:
+		return ChangeDetection{Mode: ChangeDetectionOff}, nil
+	case ChangeDetectionMark, ChangeDetectionSuppress:
+	default:
+		return ChangeDetection{}, &ValidationError{Message: fmt.Sprintf("change detection mode %q must be off, mark or suppress", mode)}
+	}
+
+	fields := []string{}
+	for _, field := range keyFields {
+		field = strings.TrimSpace(field)
+		if field != "" && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	return ChangeDetection{Mode: mode, KeyFields: fields}, nil
+}
+
+// WorkflowFromConfig validates a workflow spec from config.json or the api
+// and converts it to a workflow the service providers can deploy
+func WorkflowFromConfig(config types.WorkflowsConfig) (Workflow, error) {
+	workflowName := NormalizeWorkflowName(config.Name)
+
+	if workflowName == "" {
+		return Workflow{}, errors.New("workflow name is required")
+	}
+
+	if !workflowNamePattern.MatchString(workflowName) {
+		return Workflow{}, fmt.Errorf("workflow name %q must start with a letter and only contain letters, numbers, '_' and '-'", workflowName)
+	}
+
+	if config.Website == "" {
+		return Workflow{}, fmt.Errorf("workflow %s: website is required", workflowName)
+	}
+
+	if err := ValidateCron(config.Cron); err != nil {
+		return Workflow{}, fmt.Errorf("workflow %s: %w", workflowName, err)
+	}
+
+	changes := ChangeDetection{Mode: ChangeDetectionOff}
+	if config.ChangeDetection != nil {
+		var err error
+
+		changes, err = ParseChangeDetection(config.ChangeDetection.Mode, config.ChangeDetection.KeyFields)
+		if err != nil {
+			return Workflow{}, fmt.Errorf("workflow %s: %w", workflowName, err)
+		}
+	}
+
+	schema, err := schemaFromSpec(config)
+	if err != nil {
+		return Workflow{}, fmt.Errorf("workflow %s: %w", workflowName, err)
+	}
+
+	return Workflow{
+		Name:        workflowName,
+		Prompt:      config.Prompt,
+		Schema:      schema,
+		Cron:        config.Cron,
+		Status:      WorkflowStatusRunning,
+		Tags:        NormalizeTags(config.Tags),
+		Changes:     changes,
+		DropInvalid: config.DropInvalid,
+		Request: WorkflowRequestContext{
+			WorkflowName: workflowName,
+			Website:      config.Website,
+			Cron:         config.Cron,
+			Prompt:       config.Prompt,
+			NumberFields: len(schema.Properties),
+		},
+	}, nil
+}
+
+// schemaFromSpec converts the schema fields of a workflow spec, or its
+// json schema document when one is given instead
+func schemaFromSpec(config types.WorkflowsConfig) (Schema, error) {
+	if len(config.JSONSchema) > 0 {
+		if len(config.Schema) > 0 {
+			return Schema{}, &ValidationError{Message: "schema and json_schema cannot be used together"}
+		}
+
+		return SchemaFromJSONSchema(config.JSONSchema)
+	}
+
+	schema, err := schemaFromConfig(config.Schema)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	if len(schema.Properties) == 0 {
+		return Schema{}, ErrEmptySchema
+	}
+
+	if err := schema.Validate(); err != nil {
+		return Schema{}, err
+	}
+
+	return schema, nil
+}
+
+// ConfigFromWorkflow converts a deployed workflow back into the spec
+// format accepted by WorkflowFromConfig
+func ConfigFromWorkflow(workflow Workflow) types.WorkflowsConfig {
+	config := types.WorkflowsConfig{
+		Name:        workflow.Name,
+		Prompt:      workflow.Prompt,
+		Cron:        workflow.Cron,
+		Website:     workflow.Request.Website,
+		Status:      workflow.Status,
+		Tags:        workflow.Tags,
+		DropInvalid: workflow.DropInvalid,
+		Schema:      configFromProperties(workflow.Schema.Properties, workflow.Schema.Required),
+	}
+
+	if workflow.Changes.Enabled() {
+		config.ChangeDetection = &types.ChangeDetectionConfig{
+			Mode:      workflow.Changes.Mode,
+			KeyFields: workflow.Changes.KeyFields,
+		}
+	}
+
+	return config
+}
@@ -0,0 +1,67 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronParser accepts only the five field form. older workers still get the
+// expression as CRONTAB and parse it with apscheduler, which has no
+// descriptors like @hourly and no time zone prefix
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+// ParseCron parses a standard five field cron expression, the same
+// format the workers were scheduled with
+func ParseCron(expr string) (cron.Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		return nil, fmt.Errorf("time zone prefixes are not supported")
+	}
+
+	if strings.Contains(expr, "?") {
+		return nil, fmt.Errorf("? is not supported, use *")
+	}
+
+	return cronParser.Parse(expr)
+}
+
+// ValidateCron checks that the expression is a five field expression that
+// fires at least once
+func ValidateCron(expr string) error {
+	if expr == "" {
+		return &ValidationError{Message: "cron expression is required"}
+	}
+
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return &ValidationError{Message: fmt.Sprintf("invalid cron expression %q: %s", expr, err)}
+	}
+
+	// a schedule like "0 0 30 2 *" parses but never fires
+	if schedule.Next(time.Now()).IsZero() {
+		return &ValidationError{Message: fmt.Sprintf("invalid cron expression %q: it never runs", expr)}
+	}
+
+	return nil
+}
+
+// NextCronRuns returns the next n times the expression fires after from
+func NextCronRuns(expr string, from time.Time, n int) ([]time.Time, error) {
+	if err := ValidateCron(expr); err != nil {
+		return nil, err
+	}
+
+	schedule, _ := ParseCron(expr)
+
+	runs := make([]time.Time, 0, n)
+	next := from
+	for i := 0; i < n; i++ {
+		next = schedule.Next(next)
+		runs = append(runs, next)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,124 @@
+package infrastructure_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+)
+
+func TestValidateCron(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		// a substring of the validation error, empty when the expression is valid
+		wantErr string
+	}{
+		{name: "every minute", expr: "* * * * *"},
+		{name: "ranges and steps", expr: "*/15 9-17 * * 1-5"},
+		{name: "names", expr: "0 6 * JAN,JUL MON"},
+		{name: "empty", expr: "", wantErr: "cron expression is required"},
+		{name: "six fields", expr: "0 * * * * *", wantErr: "expected exactly 5 fields"},
+		{name: "four fields", expr: "* * * *", wantErr: "expected exactly 5 fields"},
+		{name: "descriptor", expr: "@hourly", wantErr: "invalid cron expression"},
+		{name: "every descriptor", expr: "@every 1m", wantErr: "invalid cron expression"},
+		{name: "time zone prefix", expr: "TZ=UTC * * * * *", wantErr: "time zone prefixes are not supported"},
+		{name: "cron time zone prefix", expr: "CRON_TZ=Europe/Berlin 0 9 * * *", wantErr: "time zone prefixes are not supported"},
+		{name: "question mark", expr: "0 9 ? * MON", wantErr: "? is not supported"},
+		{name: "out of range", expr: "60 * * * *", wantErr: "invalid cron expression"},
+		{name: "february 30th never fires", expr: "0 0 30 2 *", wantErr: "it never runs"},
+		{name: "april 31st never fires", expr: "0 0 31 4 *", wantErr: "it never runs"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := infrastructure.ValidateCron(test.expr)
+
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateCron(%q) returned an error: %v", test.expr, err)
+				}
+				return
+			}
+
+			var validationErr *infrastructure.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ValidateCron(%q) error = %v, want a validation error", test.expr, err)
+			}
+
+			if !strings.Contains(validationErr.Message, test.wantErr) {
+				t.Errorf("ValidateCron(%q) error = %q, want it to contain %q", test.expr, validationErr.Message, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNextCronRuns(t *testing.T) {
+	from := time.Date(2026, time.February, 27, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		expr    string
+		n       int
+		want    []time.Time
+		wantErr bool
+	}{
+		{
+			name: "every hour",
+			expr: "0 * * * *",
+			n:    3,
+			want: []time.Time{
+				time.Date(2026, time.February, 27, 11, 0, 0, 0, time.UTC),
+				time.Date(2026, time.February, 27, 12, 0, 0, 0, time.UTC),
+				time.Date(2026, time.February, 27, 13, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "skips short months",
+			expr: "0 0 31 * *",
+			n:    2,
+			want: []time.Time{
+				time.Date(2026, time.March, 31, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, time.May, 31, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			n:    1,
+			want: []time.Time{time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "no runs asked",
+			expr: "* * * * *",
+			n:    0,
+			want: []time.Time{},
+		},
+		{name: "never fires", expr: "0 0 30 2 *", n: 3, wantErr: true},
+		{name: "time zone prefix", expr: "TZ=UTC 0 * * * *", n: 3, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runs, err := infrastructure.NextCronRuns(test.expr, from, test.n)
+
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("NextCronRuns(%q) = %v, want an error", test.expr, runs)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("NextCronRuns(%q) returned an error: %v", test.expr, err)
+			}
+
+			if !reflect.DeepEqual(runs, test.want) {
+				t.Errorf("NextCronRuns(%q) = %v, want %v", test.expr, runs, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package infrastructure
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ferretcode/scavenger/pkg/types"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// CloudRunClient lets tests outside the package provide a fake cloud run
+// client
+type CloudRunClient = cloudRunClient
+
+// MaxValidationErrors lets tests check how many errors a result keeps
+const MaxValidationErrors = maxValidationErrors
+
+func NewGcpServiceProviderWithClient(config *types.ScavengerConfig, db *mongo.Client, ctx context.Context, logger *slog.Logger, runClient CloudRunClient) *GcpServiceProvider {
+	return newGcpServiceProvider(config, db, ctx, logger, runClient)
+}
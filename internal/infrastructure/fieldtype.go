@@ -0,0 +1,109 @@
+package infrastructure
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// FieldType is the type of a schema field. the values are json schema
+// types, so schemas can be sent to the worker as they are stored
+type FieldType string
+
+const (
+	FieldTypeString  FieldType = "string"
+	FieldTypeNumber  FieldType = "number"
+	FieldTypeInteger FieldType = "integer"
+	FieldTypeBoolean FieldType = "boolean"
+	FieldTypeArray   FieldType = "array"
+	FieldTypeObject  FieldType = "object"
+)
+
+var FieldTypes = []FieldType{
+	FieldTypeString,
+	FieldTypeNumber,
+	FieldTypeInteger,
+	FieldTypeBoolean,
+	FieldTypeArray,
+	FieldTypeObject,
+}
+
+// other names accepted for field types in the form, config.json and the api
+var fieldTypeAliases = map[string]FieldType{
+	"str":     FieldTypeString,
+	"text":    FieldTypeString,
+	"float":   FieldTypeNumber,
+	"double":  FieldTypeNumber,
+	"decimal": FieldTypeNumber,
+	"int":     FieldTypeInteger,
+	"bool":    FieldTypeBoolean,
+	"list":    FieldTypeArray,
+}
+
+// ParseFieldType resolves a field type name or one of its aliases
+func ParseFieldType(name string) (FieldType, error) {
+	fieldType, ok := FieldType(name).Resolve()
+	if !ok {
+		names := make([]string, len(FieldTypes))
+		for i, t := range FieldTypes {
+			names[i] = string(t)
+		}
+
+		return "", &ValidationError{Message: fmt.Sprintf("unknown field type %q, expected one of %s", name, strings.Join(names, ", "))}
+	}
+
+	return fieldType, nil
+}
+
+// Resolve returns the json schema type of a field type. workflows saved
+// before field types were checked can hold aliases or unknown types
+func (t FieldType) Resolve() (FieldType, bool) {
+	name := strings.ToLower(strings.TrimSpace(string(t)))
+
+	if alias, ok := fieldTypeAliases[name]; ok {
+		return alias, true
+	}
+
+	if slices.Contains(FieldTypes, FieldType(name)) {
+		return FieldType(name), true
+	}
+
+	return "", false
+}
+
+// Is reports whether the field type resolves to other
+func (t FieldType) Is(other FieldType) bool {
+	resolved, ok := t.Resolve()
+	return ok && resolved == other
+}
+
+// Matches reports whether a decoded json value has the field type.
+// checked is false for types that are not recognized
+func (t FieldType) Matches(value any) (matches bool, checked bool) {
+	resolved, ok := t.Resolve()
+	if !ok {
+		return false, false
+	}
+
+	switch resolved {
+	case FieldTypeString:
+		_, ok := value.(string)
+		return ok, true
+	case FieldTypeNumber:
+		_, ok := value.(float64)
+		return ok, true
+	case FieldTypeInteger:
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n), true
+	case FieldTypeBoolean:
+		_, ok := value.(bool)
+		return ok, true
+	case FieldTypeArray:
+		_, ok := value.([]any)
+		return ok, true
+	default:
+		_, ok := value.(map[string]any)
+		return ok, true
+	}
+}
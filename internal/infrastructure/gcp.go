@@ -15,10 +15,15 @@ import (
 	"github.com/ferretcode/scavenger/pkg/types"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// workflowServiceLabel marks the cloud run services of workflows with the
+// workflow name
+const workflowServiceLabel = "scavenger-workflow"
+
 const serviceIDCharset = "abcdefghijklmnopqrstuvwxyz0123456789"
 const maxServiceIDLength = 49
 
@@ -26,8 +31,10 @@ type GcpServiceProvider struct {
 	Config    *types.ScavengerConfig
 	logger    *slog.Logger
 	db        *mongo.Client
-	runClient *run.ServicesClient
+	runClient cloudRunClient
 	ctx       context.Context
+
+	deleteHooks
 }
 
 func NewGcpServiceProvider(config *types.ScavengerConfig, db *mongo.Client, ctx context.Context, logger *slog.Logger) (*GcpServiceProvider, error) {
@@ -59,13 +66,19 @@ func NewGcpServiceProvider(config *types.ScavengerConfig, db *mongo.Client, ctx
 		return nil, err
 	}
 
+	return newGcpServiceProvider(config, db, ctx, logger, servicesClient{client: runClient}), nil
+}
+
+// newGcpServiceProvider creates the provider with any cloud run client,
+// tests can pass a fake one
+func newGcpServiceProvider(config *types.ScavengerConfig, db *mongo.Client, ctx context.Context, logger *slog.Logger, runClient cloudRunClient) *GcpServiceProvider {
 	return &GcpServiceProvider{
 		Config:    config,
 		db:        db,
 		ctx:       ctx,
 		logger:    logger,
 		runClient: runClient,
-	}, nil
+	}
 }
 
 func (g *GcpServiceProvider) DeleteWorkflow(w http.ResponseWriter, r *http.Request) error {
@@ -76,17 +89,79 @@ func (g *GcpServiceProvider) DeleteWorkflow(w http.ResponseWriter, r *http.Reque
 
 	workflowName := r.PostForm.Get("workflowName")
 
-	workflow := Workflow{}
-	workflow.Name = workflowName
+	err = g.DeleteWorkflowByName(workflowName)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "http://localhost:3000/workflows", http.StatusSeeOther)
 
-	bsonFilter := bson.D{{Key: "workflowName", Value: workflowName}}
+	return nil
+}
+
+func (g *GcpServiceProvider) DeleteWorkflowByName(workflowName string) error {
+	workflow, err := FindWorkflow(g.ctx, g.db, g.Config.DatabaseName, workflowName)
+	if err == ErrNoWorkflowExists {
+		err := g.deleteOrphanedServices(workflowName)
+		if err != nil {
+			return err
+		}
 
-	_, err = g.db.Database(os.Getenv("DATABASE_NAME")).Collection("workflows").DeleteOne(g.ctx, bsonFilter)
+		return g.workflowDeleted(workflowName)
+	}
 	if err != nil {
 		return err
 	}
 
-	http.Redirect(w, r, "http://localhost:3000/workflows", http.StatusSeeOther)
+	if workflow.ServiceId != "" {
+		err := g.runClient.DeleteService(g.ctx, &runpb.DeleteServiceRequest{
+			Name: g.serviceResourceName(workflow.ServiceId),
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		g.logger.Warn("workflow has no cloud run service id, only removing it from the database", "workflow-name", workflowName)
+	}
+
+	bsonFilter := bson.D{{Key: "name", Value: workflowName}}
+
+	_, err = g.db.Database(g.Config.DatabaseName).Collection("workflows").DeleteOne(g.ctx, bsonFilter)
+	if err != nil {
+		return err
+	}
+
+	return g.workflowDeleted(workflowName)
+}
+
+// deleteOrphanedServices removes the services of a workflow that has no
+// database record, which happens when saving it failed after the service
+// was created
+func (g *GcpServiceProvider) deleteOrphanedServices(workflowName string) error {
+	services, err := g.workflowServices()
+	if err != nil {
+		return err
+	}
+
+	deleted := 0
+
+	for _, service := range services {
+		if serviceWorkflowName(service) != workflowName {
+			continue
+		}
+
+		g.logger.Warn("deleting cloud run service of workflow without a database record", "workflow-name", workflowName, "service", service.Name)
+
+		err := g.runClient.DeleteService(g.ctx, &runpb.DeleteServiceRequest{Name: service.Name})
+		if err != nil {
+			return err
+		}
+		deleted++
+	}
+
+	if deleted == 0 {
+		return ErrNoWorkflowExists
+	}
 
 	return nil
 }
@@ -126,52 +201,202 @@ func (g *GcpServiceProvider) CreateWorkflow(w http.ResponseWriter, r *http.Reque
 	return nil
 }
 
-func (g *GcpServiceProvider) CheckWorkflowExists(workflowName string) (bool, error) {
-	parent := fmt.Sprintf("projects/%s/locations/%s", g.Config.GcpProjectId, g.Config.GcpLocation)
+func (g *GcpServiceProvider) UpdateWorkflow(w http.ResponseWriter, r *http.Request) error {
+	workflow, err := generateWorkflowFromRequest(r)
+	if err != nil {
+		return err
+	}
 
-	requestRunPB := &runpb.ListServicesRequest{
-		Parent: parent,
+	err = g.UpdateWorkflowFromConfig(*workflow)
+	if err != nil {
+		return err
 	}
 
-	resp := g.runClient.ListServices(g.ctx, requestRunPB)
-	done := false
+	http.Redirect(w, r, "/workflows", http.StatusSeeOther)
 
-	for !done {
-		if service, err := resp.Next(); err != iterator.Done {
-			if _, ok := service.Labels[""]; ok {
-				return true, nil
-			} else {
-				return false, ErrNoWorkflowExists
-			}
-		} else {
-			done = true
-		}
+	return nil
+}
+
+func (g *GcpServiceProvider) UpdateWorkflowFromConfig(workflow Workflow) error {
+	existing, err := FindWorkflow(g.ctx, g.db, g.Config.DatabaseName, workflow.Name)
+	if err != nil {
+		return err
+	}
+
+	schemaString, err := json.Marshal(workflow.Schema)
+	if err != nil {
+		return err
+	}
+
+	workflow.RunToken = existing.RunToken
+	if err := ensureRunToken(&workflow); err != nil {
+		return err
+	}
+
+	if existing.ServiceId == "" {
+		return g.redeployWorkflow(*existing, workflow, string(schemaString))
+	}
+
+	service, err := g.runClient.GetService(g.ctx, &runpb.GetServiceRequest{
+		Name: g.serviceResourceName(existing.ServiceId),
+	})
+	if status.Code(err) == codes.NotFound {
+		return g.redeployWorkflow(*existing, workflow, string(schemaString))
+	}
+	if err != nil {
+		return err
+	}
+
+	// replacing the template rolls out a new revision behind the same uri
+	service.Template = g.revisionTemplate(workflow, string(schemaString))
+
+	if service.Labels == nil {
+		service.Labels = make(map[string]string)
+	}
+	service.Labels[workflowServiceLabel] = workflow.Name
+
+	service, err = g.runClient.UpdateService(g.ctx, &runpb.UpdateServiceRequest{
+		Service: service,
+	})
+	if err != nil {
+		return err
+	}
+
+	workflow.ServiceUri = service.Uri
+	workflow.ServiceId = existing.ServiceId
+
+	return updateWorkflowDocument(g.ctx, g.db, g.Config.DatabaseName, workflow)
+}
+
+// redeployWorkflow creates a new service for a workflow whose service is
+// gone. the record keeps its status, run token and counters
+func (g *GcpServiceProvider) redeployWorkflow(existing Workflow, workflow Workflow, schemaString string) error {
+	g.logger.Warn("cloud run service of workflow not found, creating a new one", "workflow-name", workflow.Name)
+
+	service, serviceId, err := g.createService(workflow, schemaString, existing.IsPaused())
+	if err != nil {
+		return err
 	}
 
-	return true, nil
+	workflow.ServiceUri = service.Uri
+	workflow.ServiceId = serviceId
+
+	return updateWorkflowDocument(g.ctx, g.db, g.Config.DatabaseName, workflow)
 }
 
-func (g *GcpServiceProvider) GetRunningWorkflows() (int, error) {
+func (g *GcpServiceProvider) PauseWorkflow(workflowName string) error {
+	manualInstanceCount := int32(0)
+
+	err := g.setServiceScaling(workflowName, &runpb.ServiceScaling{
+		ScalingMode:         runpb.ServiceScaling_MANUAL,
+		ManualInstanceCount: &manualInstanceCount,
+	})
+	if err != nil {
+		return err
+	}
+
+	return setWorkflowStatus(g.ctx, g.db, g.Config.DatabaseName, workflowName, WorkflowStatusPaused)
+}
+
+func (g *GcpServiceProvider) ResumeWorkflow(workflowName string) error {
+	err := g.setServiceScaling(workflowName, &runpb.ServiceScaling{
+		ScalingMode: runpb.ServiceScaling_AUTOMATIC,
+	})
+	if err != nil {
+		return err
+	}
+
+	return setWorkflowStatus(g.ctx, g.db, g.Config.DatabaseName, workflowName, WorkflowStatusRunning)
+}
+
+func (g *GcpServiceProvider) setServiceScaling(workflowName string, scaling *runpb.ServiceScaling) error {
+	workflow, err := FindWorkflow(g.ctx, g.db, g.Config.DatabaseName, workflowName)
+	if err != nil {
+		return err
+	}
 
-	parent := fmt.Sprintf("projects/%s/locations/%s", g.Config.GcpProjectId, g.Config.GcpLocation)
+	if workflow.ServiceId == "" {
+		return fmt.Errorf("workflow %s has no cloud run service id and cannot be scaled", workflowName)
+	}
+
+	service, err := g.runClient.GetService(g.ctx, &runpb.GetServiceRequest{
+		Name: g.serviceResourceName(workflow.ServiceId),
+	})
+	if err != nil {
+		return err
+	}
+
+	service.Scaling = scaling
+
+	_, err = g.runClient.UpdateService(g.ctx, &runpb.UpdateServiceRequest{
+		Service: service,
+	})
+
+	return err
+}
+
+func (g *GcpServiceProvider) CheckWorkflowExists(workflowName string) (bool, error) {
+	deployed, err := g.DeployedWorkflows()
+	if err != nil {
+		return false, err
+	}
+
+	return deployed[workflowName], nil
+}
+
+// DeployedWorkflows returns the names of the workflows that have a cloud
+// run service
+func (g *GcpServiceProvider) DeployedWorkflows() (map[string]bool, error) {
+	services, err := g.workflowServices()
+	if err != nil {
+		return nil, err
+	}
 
-	requestRunPB := &runpb.ListServicesRequest{
-		Parent: parent,
+	deployed := make(map[string]bool)
+	for _, service := range services {
+		deployed[serviceWorkflowName(service)] = true
 	}
 
-	resp := g.runClient.ListServices(g.ctx, requestRunPB)
-	done := false
-	totalContainers := 0
+	return deployed, nil
+}
+
+func (g *GcpServiceProvider) GetRunningWorkflows() (int, error) {
+	services, err := g.workflowServices()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(services), nil
+}
+
+// workflowServices lists the cloud run services that run workflows,
+// skipping any other services in the project
+func (g *GcpServiceProvider) workflowServices() ([]*runpb.Service, error) {
+	services, err := g.runClient.ListServices(g.ctx, &runpb.ListServicesRequest{
+		Parent: g.locationName(),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	for !done {
-		if _, err := resp.Next(); err != iterator.Done {
-			totalContainers++
-		} else {
-			done = true
+	workflowServices := []*runpb.Service{}
+	for _, service := range services {
+		if serviceWorkflowName(service) != "" {
+			workflowServices = append(workflowServices, service)
 		}
 	}
 
-	return totalContainers, nil
+	return workflowServices, nil
+}
+
+// serviceWorkflowName returns the workflow a service runs. services created
+// before they were labelled only carry the label on their revision template
+func serviceWorkflowName(service *runpb.Service) string {
+	if name := service.GetLabels()[workflowServiceLabel]; name != "" {
+		return name
+	}
+
+	return service.GetTemplate().GetLabels()["workflow"]
 }
 
 func (g *GcpServiceProvider) createWorkflow(workflow Workflow, schemaString string) error {
@@ -186,91 +411,60 @@ func (g *GcpServiceProvider) createWorkflow(workflow Workflow, schemaString stri
 		return nil // no-op since workflow exists already
 	}
 
+	err = ensureRunToken(&workflow)
+	if err != nil {
+		return err
+	}
+
+	service, serviceId, err := g.createService(workflow, schemaString, false)
+	if err != nil {
+		return err
+	}
+
+	workflow.ServiceUri = service.Uri
+	workflow.ServiceId = serviceId
+
+	_, err = g.db.Database(g.Config.DatabaseName).Collection("workflows").InsertOne(g.ctx, workflow)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// createService creates a publicly invokable cloud run service for the
+// workflow and returns it with its id. paused workflows get a service
+// scaled to zero
+func (g *GcpServiceProvider) createService(workflow Workflow, schemaString string, paused bool) (*runpb.Service, string, error) {
 	createServiceRequest := &runpb.CreateServiceRequest{
-		Parent:    fmt.Sprintf("projects/%s/locations/%s", os.Getenv("GCP_PROJECT_ID"), os.Getenv("GCP_LOCATION")),
+		Parent:    g.locationName(),
 		ServiceId: generateServiceID(),
 		Service: &runpb.Service{
-			Template: &runpb.RevisionTemplate{
-				Labels: map[string]string{"workflow": workflow.Name},
-				Containers: []*runpb.Container{
-					{
-						Image: "sthanguy/scavenger-scraper",
-						Ports: []*runpb.ContainerPort{
-							{
-								ContainerPort: 8765, // scraper websocket port
-							},
-						},
-						Resources: &runpb.ResourceRequirements{
-							Limits: map[string]string{
-								"memory": "1.5 Gi",
-							},
-						},
-						StartupProbe: &runpb.Probe{
-							InitialDelaySeconds: 5,
-							PeriodSeconds:       2,
-							FailureThreshold:    1000,
-							ProbeType: &runpb.Probe_HttpGet{
-								HttpGet: &runpb.HTTPGetAction{
-									Path: "/healthz",
-									Port: 8765,
-								},
-							},
-						},
-						Env: []*runpb.EnvVar{
-							{
-								Name: "CRONTAB",
-								Values: &runpb.EnvVar_Value{
-									Value: workflow.Cron,
-								},
-							},
-							{
-								Name: "GEMINI_API_KEY",
-								Values: &runpb.EnvVar_Value{
-									Value: os.Getenv("GEMINI_API_KEY"),
-								},
-							},
-							{
-								Name: "SCHEMA",
-								Values: &runpb.EnvVar_Value{
-									Value: string(schemaString),
-								},
-							},
-							{
-								Name: "PROMPT",
-								Values: &runpb.EnvVar_Value{
-									Value: workflow.Prompt,
-								},
-							},
-							{
-								Name: "WEBPAGE_URL",
-								Values: &runpb.EnvVar_Value{
-									Value: workflow.Request.Website,
-								},
-							},
-						},
-					},
-				},
-			},
+			Labels:   map[string]string{workflowServiceLabel: workflow.Name},
+			Template: g.revisionTemplate(workflow, schemaString),
 		},
 	}
 
-	resp, err := g.runClient.CreateService(g.ctx, createServiceRequest)
-	if err != nil {
-		return err
+	if paused {
+		manualInstanceCount := int32(0)
+		createServiceRequest.Service.Scaling = &runpb.ServiceScaling{
+			ScalingMode:         runpb.ServiceScaling_MANUAL,
+			ManualInstanceCount: &manualInstanceCount,
+		}
 	}
 
-	service, err := resp.Wait(g.ctx)
+	service, err := g.runClient.CreateService(g.ctx, createServiceRequest)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	resource := fmt.Sprintf("projects/%s/locations/%s/services/%s", os.Getenv("GCP_PROJECT_ID"), os.Getenv("GCP_LOCATION"), createServiceRequest.ServiceId)
+	resource := g.serviceResourceName(createServiceRequest.ServiceId)
 
 	policy, err := g.runClient.GetIamPolicy(g.ctx, &iampb.GetIamPolicyRequest{
 		Resource: resource,
 	})
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
 	policy.Bindings = append(policy.Bindings, &iampb.Binding{
@@ -283,15 +477,96 @@ func (g *GcpServiceProvider) createWorkflow(workflow Workflow, schemaString stri
 		Policy:   policy,
 	})
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	workflow.ServiceUri = service.Uri
+	return service, createServiceRequest.ServiceId, nil
+}
 
-	_, err = g.db.Database(g.Config.DatabaseName).Collection("workflows").InsertOne(g.ctx, workflow)
-	if err != nil {
-		return err
-	}
+func (g *GcpServiceProvider) locationName() string {
+	return fmt.Sprintf("projects/%s/locations/%s", g.Config.GcpProjectId, g.Config.GcpLocation)
+}
 
-	return nil
+func (g *GcpServiceProvider) serviceResourceName(serviceId string) string {
+	return fmt.Sprintf("%s/services/%s", g.locationName(), serviceId)
+}
+
+// revisionTemplate builds the cloud run revision for a workflow worker
+func (g *GcpServiceProvider) revisionTemplate(workflow Workflow, schemaString string) *runpb.RevisionTemplate {
+	return &runpb.RevisionTemplate{
+		Labels: map[string]string{"workflow": workflow.Name},
+		Containers: []*runpb.Container{
+			{
+				Image: workerImage(g.Config),
+				Ports: []*runpb.ContainerPort{
+					{
+						ContainerPort: 8765, // scraper websocket port
+					},
+				},
+				Resources: &runpb.ResourceRequirements{
+					Limits: map[string]string{
+						"memory": "1.5 Gi",
+					},
+				},
+				StartupProbe: &runpb.Probe{
+					InitialDelaySeconds: 5,
+					PeriodSeconds:       2,
+					FailureThreshold:    1000,
+					ProbeType: &runpb.Probe_HttpGet{
+						HttpGet: &runpb.HTTPGetAction{
+							Path: "/healthz",
+							Port: 8765,
+						},
+					},
+				},
+				// the published worker image still reads CRONTAB at startup,
+				// newer workers ignore it when the control plane schedules
+				// their runs
+				Env: []*runpb.EnvVar{
+					{
+						Name: "CRONTAB",
+						Values: &runpb.EnvVar_Value{
+							Value: workflow.Cron,
+						},
+					},
+					{
+						Name: "CONTROL_PLANE_SCHEDULING",
+						Values: &runpb.EnvVar_Value{
+							Value: "true",
+						},
+					},
+					{
+						Name: "GEMINI_API_KEY",
+						Values: &runpb.EnvVar_Value{
+							Value: os.Getenv("GEMINI_API_KEY"),
+						},
+					},
+					{
+						Name: "SCHEMA",
+						Values: &runpb.EnvVar_Value{
+							Value: string(schemaString),
+						},
+					},
+					{
+						Name: "PROMPT",
+						Values: &runpb.EnvVar_Value{
+							Value: workflow.Prompt,
+						},
+					},
+					{
+						Name: "WEBPAGE_URL",
+						Values: &runpb.EnvVar_Value{
+							Value: workflow.Request.Website,
+						},
+					},
+					{
+						Name: "RUN_TOKEN",
+						Values: &runpb.EnvVar_Value{
+							Value: workflow.RunToken,
+						},
+					},
+				},
+			},
+		},
+	}
 }
@@ -0,0 +1,86 @@
+package infrastructure
+
+import (
+	"context"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	run "cloud.google.com/go/run/apiv2"
+	"cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/api/iterator"
+)
+
+// cloudRunClient is the part of the cloud run services api used by the gcp
+// provider. long running operations are waited on and listings are read to
+// the end, so a fake client only has to return plain values
+type cloudRunClient interface {
+	CreateService(ctx context.Context, req *runpb.CreateServiceRequest) (*runpb.Service, error)
+	GetService(ctx context.Context, req *runpb.GetServiceRequest) (*runpb.Service, error)
+	UpdateService(ctx context.Context, req *runpb.UpdateServiceRequest) (*runpb.Service, error)
+	DeleteService(ctx context.Context, req *runpb.DeleteServiceRequest) error
+	ListServices(ctx context.Context, req *runpb.ListServicesRequest) ([]*runpb.Service, error)
+	GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error)
+	SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error)
+}
+
+// servicesClient implements cloudRunClient with the cloud run api
+type servicesClient struct {
+	client *run.ServicesClient
+}
+
+func (c servicesClient) CreateService(ctx context.Context, req *runpb.CreateServiceRequest) (*runpb.Service, error) {
+	op, err := c.client.CreateService(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return op.Wait(ctx)
+}
+
+func (c servicesClient) GetService(ctx context.Context, req *runpb.GetServiceRequest) (*runpb.Service, error) {
+	return c.client.GetService(ctx, req)
+}
+
+func (c servicesClient) UpdateService(ctx context.Context, req *runpb.UpdateServiceRequest) (*runpb.Service, error) {
+	op, err := c.client.UpdateService(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return op.Wait(ctx)
+}
+
+func (c servicesClient) DeleteService(ctx context.Context, req *runpb.DeleteServiceRequest) error {
+	op, err := c.client.DeleteService(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	_, err = op.Wait(ctx)
+
+	return err
+}
+
+func (c servicesClient) ListServices(ctx context.Context, req *runpb.ListServicesRequest) ([]*runpb.Service, error) {
+	it := c.client.ListServices(ctx, req)
+	services := []*runpb.Service{}
+
+	for {
+		service, err := it.Next()
+		if err == iterator.Done {
+			return services, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		services = append(services, service)
+	}
+}
+
+func (c servicesClient) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error) {
+	return c.client.GetIamPolicy(ctx, req)
+}
+
+func (c servicesClient) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	return c.client.SetIamPolicy(ctx, req)
+}
@@ -0,0 +1,571 @@
+package infrastructure_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/run/apiv2/runpb"
+	"github.com/ferretcode/scavenger/internal/bootstrap"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/drivertest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testLocation = "projects/test-project/locations/us-central1"
+
+// fakeRunClient keeps cloud run services and their iam policies in memory
+type fakeRunClient struct {
+	mu       sync.Mutex
+	services map[string]*runpb.Service // map[resourceName]service
+	policies map[string]*iampb.Policy  // map[resourceName]policy
+	deleted  []string
+}
+
+var _ infrastructure.CloudRunClient = (*fakeRunClient)(nil)
+
+func newFakeRunClient(services ...*runpb.Service) *fakeRunClient {
+	client := &fakeRunClient{
+		services: make(map[string]*runpb.Service),
+		policies: make(map[string]*iampb.Policy),
+	}
+
+	for _, service := range services {
+		client.services[service.Name] = service
+	}
+
+	return client
+}
+
+func (f *fakeRunClient) CreateService(ctx context.Context, req *runpb.CreateServiceRequest) (*runpb.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	service := req.Service
+	service.Name = req.Parent + "/services/" + req.ServiceId
+	service.Uri = "https://" + req.ServiceId + ".a.run.app"
+
+	f.services[service.Name] = service
+
+	return service, nil
+}
+
+func (f *fakeRunClient) GetService(ctx context.Context, req *runpb.GetServiceRequest) (*runpb.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	service, ok := f.services[req.Name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "service not found")
+	}
+
+	return service, nil
+}
+
+func (f *fakeRunClient) UpdateService(ctx context.Context, req *runpb.UpdateServiceRequest) (*runpb.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.services[req.Service.Name]; !ok {
+		return nil, status.Error(codes.NotFound, "service not found")
+	}
+
+	f.services[req.Service.Name] = req.Service
+
+	return req.Service, nil
+}
+
+func (f *fakeRunClient) DeleteService(ctx context.Context, req *runpb.DeleteServiceRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.services[req.Name]; !ok {
+		return status.Error(codes.NotFound, "service not found")
+	}
+
+	delete(f.services, req.Name)
+	f.deleted = append(f.deleted, req.Name)
+
+	return nil
+}
+
+func (f *fakeRunClient) ListServices(ctx context.Context, req *runpb.ListServicesRequest) ([]*runpb.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	services := []*runpb.Service{}
+	for name, service := range f.services {
+		if strings.HasPrefix(name, req.Parent+"/services/") {
+			services = append(services, service)
+		}
+	}
+
+	return services, nil
+}
+
+func (f *fakeRunClient) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if policy, ok := f.policies[req.Resource]; ok {
+		return policy, nil
+	}
+
+	return &iampb.Policy{}, nil
+}
+
+func (f *fakeRunClient) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.policies[req.Resource] = req.Policy
+
+	return req.Policy, nil
+}
+
+func workflowService(serviceId string, labels map[string]string, templateLabels map[string]string) *runpb.Service {
+	return &runpb.Service{
+		Name:     testLocation + "/services/" + serviceId,
+		Labels:   labels,
+		Template: &runpb.RevisionTemplate{Labels: templateLabels},
+	}
+}
+
+// mockDatabase returns a mongo client that answers every command with the
+// given replies in order, without a server
+func mockDatabase(t *testing.T, replies ...bson.D) *mongo.Client {
+	t.Helper()
+
+	clientOptions := options.Client()
+	clientOptions.Deployment = drivertest.NewMockDeployment(replies...)
+
+	db, err := mongo.Connect(clientOptions)
+	if err != nil {
+		t.Fatalf("failed to create mock database client: %v", err)
+	}
+
+	return db
+}
+
+// cursorReply is the reply to a find command returning documents
+func cursorReply(t *testing.T, documents ...any) bson.D {
+	t.Helper()
+
+	batch := bson.A{}
+	for _, document := range documents {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatalf("failed to marshal document: %v", err)
+		}
+		batch = append(batch, bson.Raw(raw))
+	}
+
+	return bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "cursor", Value: bson.D{
+			{Key: "id", Value: int64(0)},
+			{Key: "ns", Value: "scavenger.workflows"},
+			{Key: "firstBatch", Value: batch},
+		}},
+	}
+}
+
+func newTestProvider(t *testing.T, db *mongo.Client, runClient *fakeRunClient) (*infrastructure.GcpServiceProvider, *types.ScavengerConfig) {
+	t.Helper()
+
+	config := &types.ScavengerConfig{
+		DatabaseName: "scavenger",
+		GcpProjectId: "test-project",
+		GcpLocation:  "us-central1",
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return infrastructure.NewGcpServiceProviderWithClient(config, db, context.Background(), logger, runClient), config
+}
+
+func TestCheckWorkflowExists(t *testing.T) {
+	runClient := newFakeRunClient(
+		workflowService("labelled", map[string]string{"scavenger-workflow": "prices"}, nil),
+		// services created before they were labelled
+		workflowService("unlabelled", nil, map[string]string{"workflow": "news"}),
+		workflowService("other", map[string]string{"team": "web"}, nil),
+	)
+
+	provider, _ := newTestProvider(t, nil, runClient)
+
+	tests := []struct {
+		workflowName string
+		exists       bool
+	}{
+		{"prices", true},
+		{"news", true},
+		{"web", false},
+		{"missing", false},
+	}
+
+	for _, test := range tests {
+		exists, err := provider.CheckWorkflowExists(test.workflowName)
+		if err != nil {
+			t.Fatalf("CheckWorkflowExists(%q) returned an error: %v", test.workflowName, err)
+		}
+		if exists != test.exists {
+			t.Errorf("CheckWorkflowExists(%q) = %v, want %v", test.workflowName, exists, test.exists)
+		}
+	}
+
+	running, err := provider.GetRunningWorkflows()
+	if err != nil {
+		t.Fatalf("GetRunningWorkflows returned an error: %v", err)
+	}
+	if running != 2 {
+		t.Errorf("GetRunningWorkflows() = %d, want 2", running)
+	}
+}
+
+func TestCreateWorkflowLabelsService(t *testing.T) {
+	db := mockDatabase(t, bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}})
+	runClient := newFakeRunClient()
+	provider, _ := newTestProvider(t, db, runClient)
+
+	workflow, err := infrastructure.WorkflowFromConfig(types.WorkflowsConfig{
+		Name:    "prices",
+		Prompt:  "extract the prices",
+		Cron:    "0 * * * *",
+		Website: "https://example.com",
+		Schema: map[string]types.WorkflowSchemaField{
+			"price": {Name: "Price", Type: "number", Desc: "the price"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("WorkflowFromConfig returned an error: %v", err)
+	}
+
+	err = provider.CreateWorkflowFromConfig(workflow)
+	if err != nil {
+		t.Fatalf("CreateWorkflowFromConfig returned an error: %v", err)
+	}
+
+	services, _ := runClient.ListServices(context.Background(), &runpb.ListServicesRequest{Parent: testLocation})
+	if len(services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(services))
+	}
+
+	service := services[0]
+
+	if got := service.Labels["scavenger-workflow"]; got != "prices" {
+		t.Errorf("service label = %q, want %q", got, "prices")
+	}
+	if got := service.Template.Labels["workflow"]; got != "prices" {
+		t.Errorf("revision label = %q, want %q", got, "prices")
+	}
+
+	env := make(map[string]string)
+	for _, envVar := range service.Template.Containers[0].Env {
+		env[envVar.Name] = envVar.GetValue()
+	}
+	if env["RUN_TOKEN"] == "" {
+		t.Error("expected the worker to be given a run token")
+	}
+
+	policy := runClient.policies[service.Name]
+	if policy == nil || len(policy.Bindings) != 1 || policy.Bindings[0].Role != "roles/run.invoker" {
+		t.Errorf("expected the service to be made invokable, got policy %v", policy)
+	}
+
+	exists, err := provider.CheckWorkflowExists("prices")
+	if err != nil || !exists {
+		t.Errorf("CheckWorkflowExists(%q) = %v, %v after creating it", "prices", exists, err)
+	}
+}
+
+func TestDeleteOrphanedWorkflow(t *testing.T) {
+	runClient := newFakeRunClient(
+		workflowService("orphan", map[string]string{"scavenger-workflow": "prices"}, nil),
+		workflowService("kept", map[string]string{"scavenger-workflow": "news"}, nil),
+	)
+
+	// the workflow has no database record
+	db := mockDatabase(t, cursorReply(t))
+	provider, _ := newTestProvider(t, db, runClient)
+
+	err := provider.DeleteWorkflowByName("prices")
+	if err != nil {
+		t.Fatalf("DeleteWorkflowByName returned an error: %v", err)
+	}
+
+	if len(runClient.deleted) != 1 || runClient.deleted[0] != testLocation+"/services/orphan" {
+		t.Errorf("deleted services = %v, want only the orphaned service", runClient.deleted)
+	}
+
+	// nothing is left to delete the second time
+	db = mockDatabase(t, cursorReply(t))
+	provider, _ = newTestProvider(t, db, runClient)
+
+	err = provider.DeleteWorkflowByName("prices")
+	if err != infrastructure.ErrNoWorkflowExists {
+		t.Errorf("DeleteWorkflowByName returned %v, want ErrNoWorkflowExists", err)
+	}
+}
+
+func TestReconcilerPlan(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configPath, []byte(`[
+		{"name": "new", "prompt": "p", "cron": "0 * * * *", "website": "https://example.com", "schema": {"a": {"title": "A", "type": "string", "description": "a"}}},
+		{"name": "orphan", "prompt": "p", "cron": "0 * * * *", "website": "https://example.com", "schema": {"a": {"title": "A", "type": "string", "description": "a"}}},
+		{"name": "missing", "prompt": "p", "cron": "0 * * * *", "website": "https://example.com", "schema": {"a": {"title": "A", "type": "string", "description": "a"}}},
+		{"name": "same", "prompt": "p", "cron": "0 * * * *", "website": "https://example.com", "schema": {"a": {"title": "A", "type": "string", "description": "a"}}}
+	]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := func(name string, source string) infrastructure.Workflow {
+		workflow, err := infrastructure.WorkflowFromConfig(types.WorkflowsConfig{
+			Name:    name,
+			Prompt:  "p",
+			Cron:    "0 * * * *",
+			Website: "https://example.com",
+			Schema: map[string]types.WorkflowSchemaField{
+				"a": {Name: "A", Type: "string", Desc: "a"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		workflow.Source = source
+		return workflow
+	}
+
+	db := mockDatabase(t, cursorReply(t,
+		stored("missing", infrastructure.WorkflowSourceConfig),
+		stored("same", infrastructure.WorkflowSourceConfig),
+		stored("removed", infrastructure.WorkflowSourceConfig),
+	))
+
+	runClient := newFakeRunClient(
+		workflowService("orphan", map[string]string{"scavenger-workflow": "orphan"}, nil),
+		workflowService("same", map[string]string{"scavenger-workflow": "same"}, nil),
+		workflowService("removed", map[string]string{"scavenger-workflow": "removed"}, nil),
+	)
+
+	provider, config := newTestProvider(t, db, runClient)
+	config.ConfigPath = configPath
+
+	reconciler := bootstrap.NewReconciler(config, db, slog.New(slog.NewTextHandler(io.Discard, nil)), context.Background(), provider)
+
+	plan, err := reconciler.Plan()
+	if err != nil {
+		t.Fatalf("Plan returned an error: %v", err)
+	}
+
+	actions := make(map[string]string)
+	for _, step := range plan.Steps {
+		actions[step.Name] = step.Action
+	}
+
+	want := map[string]string{
+		"new":     bootstrap.ActionCreate,
+		"orphan":  bootstrap.ActionRecreate,
+		"missing": bootstrap.ActionRedeploy,
+		"same":    bootstrap.ActionUnchanged,
+		"removed": bootstrap.ActionDelete,
+	}
+
+	if len(actions) != len(want) {
+		t.Errorf("plan has steps %v, want %v", actions, want)
+	}
+
+	for name, action := range want {
+		if actions[name] != action {
+			t.Errorf("plan action for %s = %q, want %q", name, actions[name], action)
+		}
+	}
+
+	if len(runClient.deleted) != 0 {
+		t.Errorf("planning deleted services %v", runClient.deleted)
+	}
+}
+
+// skipCreateProvider creates nothing, like a provider that finds the
+// workflow already deployed
+type skipCreateProvider struct {
+	*infrastructure.GcpServiceProvider
+}
+
+func (p skipCreateProvider) CreateWorkflowFromConfig(workflow infrastructure.Workflow) error {
+	return nil
+}
+
+func TestReconcilerRecreateOrphan(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configPath, []byte(`[
+		{"name": "prices", "prompt": "p", "cron": "0 * * * *", "website": "https://example.com", "schema": {"a": {"title": "A", "type": "string", "description": "a"}}}
+	]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workflow, err := infrastructure.WorkflowFromConfig(types.WorkflowsConfig{
+		Name:    "prices",
+		Prompt:  "p",
+		Cron:    "0 * * * *",
+		Website: "https://example.com",
+		Schema: map[string]types.WorkflowSchemaField{
+			"a": {Name: "A", Type: "string", Desc: "a"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a paused service scaled to zero, left behind without a database record
+	orphan := func() *runpb.Service {
+		service := workflowService("orphan", map[string]string{"scavenger-workflow": "prices"}, nil)
+		manualInstanceCount := int32(0)
+		service.Scaling = &runpb.ServiceScaling{
+			ScalingMode:         runpb.ServiceScaling_MANUAL,
+			ManualInstanceCount: &manualInstanceCount,
+		}
+		return service
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("replaces the orphan", func(t *testing.T) {
+		db := mockDatabase(t,
+			cursorReply(t), // plan lists no workflows
+			cursorReply(t), // delete finds no record
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}}, // create inserts the record
+			cursorReply(t, workflow),                            // the record is checked
+		)
+		runClient := newFakeRunClient(orphan())
+		provider, config := newTestProvider(t, db, runClient)
+		config.ConfigPath = configPath
+
+		reconciler := bootstrap.NewReconciler(config, db, logger, context.Background(), provider)
+
+		plan, err := reconciler.Plan()
+		if err != nil {
+			t.Fatalf("Plan returned an error: %v", err)
+		}
+
+		if len(plan.Steps) != 1 || plan.Steps[0].Action != bootstrap.ActionRecreate {
+			t.Fatalf("plan steps = %v, want a single recreate", plan.Steps)
+		}
+
+		applied, errs := reconciler.Apply(plan)
+		if len(errs) != 0 {
+			t.Fatalf("Apply returned errors: %v", errs)
+		}
+		if len(applied) != 1 {
+			t.Errorf("applied steps = %v, want the recreate", applied)
+		}
+
+		if len(runClient.deleted) != 1 || runClient.deleted[0] != testLocation+"/services/orphan" {
+			t.Errorf("deleted services = %v, want the orphaned service", runClient.deleted)
+		}
+
+		services, _ := runClient.ListServices(context.Background(), &runpb.ListServicesRequest{Parent: testLocation})
+		if len(services) != 1 || services[0].Name == testLocation+"/services/orphan" {
+			t.Errorf("expected a single new service, got %v", services)
+		}
+	})
+
+	t.Run("redeploys a paused workflow", func(t *testing.T) {
+		stored := workflow
+		stored.Source = infrastructure.WorkflowSourceConfig
+		stored.Status = infrastructure.WorkflowStatusPaused
+		stored.ServiceId = "gone"
+		stored.RunToken = "stored-token"
+		stored.ValidationFailures = 3
+
+		db := mockDatabase(t,
+			cursorReply(t, stored), // plan lists the paused workflow
+			cursorReply(t, stored), // update finds the record
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}}, // the new service is saved
+		)
+		runClient := newFakeRunClient()
+		provider, config := newTestProvider(t, db, runClient)
+		config.ConfigPath = configPath
+
+		reconciler := bootstrap.NewReconciler(config, db, logger, context.Background(), provider)
+
+		plan, err := reconciler.Plan()
+		if err != nil {
+			t.Fatalf("Plan returned an error: %v", err)
+		}
+
+		if len(plan.Steps) != 1 || plan.Steps[0].Action != bootstrap.ActionRedeploy {
+			t.Fatalf("plan steps = %v, want a single redeploy", plan.Steps)
+		}
+
+		applied, errs := reconciler.Apply(plan)
+		if len(errs) != 0 {
+			t.Fatalf("Apply returned errors: %v", errs)
+		}
+		if len(applied) != 1 {
+			t.Errorf("applied steps = %v, want the redeploy", applied)
+		}
+
+		services, _ := runClient.ListServices(context.Background(), &runpb.ListServicesRequest{Parent: testLocation})
+		if len(services) != 1 {
+			t.Fatalf("expected a single new service, got %v", services)
+		}
+
+		service := services[0]
+
+		if service.Scaling.GetScalingMode() != runpb.ServiceScaling_MANUAL || service.Scaling.GetManualInstanceCount() != 0 {
+			t.Errorf("service scaling = %v, want the paused workflow scaled to zero", service.Scaling)
+		}
+
+		env := make(map[string]string)
+		for _, envVar := range service.Template.Containers[0].Env {
+			env[envVar.Name] = envVar.GetValue()
+		}
+		if env["RUN_TOKEN"] != "stored-token" {
+			t.Errorf("RUN_TOKEN = %q, want the stored run token", env["RUN_TOKEN"])
+		}
+
+		if len(runClient.deleted) != 0 {
+			t.Errorf("redeploying deleted services %v", runClient.deleted)
+		}
+	})
+
+	t.Run("fails without a record", func(t *testing.T) {
+		db := mockDatabase(t,
+			cursorReply(t), // plan lists no workflows
+			cursorReply(t), // delete finds no record
+			cursorReply(t), // the record is checked
+		)
+		runClient := newFakeRunClient(orphan())
+		provider, config := newTestProvider(t, db, runClient)
+		config.ConfigPath = configPath
+
+		reconciler := bootstrap.NewReconciler(config, db, logger, context.Background(), skipCreateProvider{provider})
+
+		plan, err := reconciler.Plan()
+		if err != nil {
+			t.Fatalf("Plan returned an error: %v", err)
+		}
+
+		applied, errs := reconciler.Apply(plan)
+		if len(applied) != 0 {
+			t.Errorf("applied steps = %v, want none", applied)
+		}
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "no database record") {
+			t.Errorf("Apply returned errors %v, want a missing record error", errs)
+		}
+	})
+}
@@ -1,28 +1,96 @@
 package infrastructure
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 var ErrNoWorkflowExists = errors.New("this workflow does not exist")
 
+// ValidationError is returned when a workflow spec submitted by a user is
+// invalid, its message is safe to show back to the user
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// workflows created from the config file are owned by it, removing them
+// from the file deletes them. workflows created in the ui or the api have
+// no source and are left alone
+const WorkflowSourceConfig = "config"
+
+const (
+	WorkflowStatusRunning = "running"
+	WorkflowStatusPaused  = "paused"
+	// the deployment of the workflow was lost, updating it again deploys
+	// it from its stored spec
+	WorkflowStatusFailed = "failed"
+)
+
+const (
+	ChangeDetectionOff      = "off"
+	ChangeDetectionMark     = "mark"
+	ChangeDetectionSuppress = "suppress"
+)
+
 type ServiceProvider interface {
 	CreateWorkflow(w http.ResponseWriter, r *http.Request) error
 	CreateWorkflowFromConfig(workflow Workflow) error
 	DeleteWorkflow(w http.ResponseWriter, r *http.Request) error
+	DeleteWorkflowByName(workflowName string) error
+	UpdateWorkflow(w http.ResponseWriter, r *http.Request) error
+	UpdateWorkflowFromConfig(workflow Workflow) error
+	PauseWorkflow(workflowName string) error
+	ResumeWorkflow(workflowName string) error
 	CheckWorkflowExists(workflowName string) (bool, error)
+	DeployedWorkflows() (map[string]bool, error)
 	GetRunningWorkflows() (int, error)
+	OnWorkflowDeleted(hook func(workflowName string) error)
+}
+
+// deleteHooks lets services that keep data per workflow remove it when the
+// workflow is deleted, so a new workflow with the same name starts clean
+type deleteHooks struct {
+	hooks []func(workflowName string) error
 }
 
+// OnWorkflowDeleted registers a function called after a workflow is deleted
+func (d *deleteHooks) OnWorkflowDeleted(hook func(workflowName string) error) {
+	d.hooks = append(d.hooks, hook)
+}
+
+func (d *deleteHooks) workflowDeleted(workflowName string) error {
+	for _, hook := range d.hooks {
+		if err := hook(workflowName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Field is a json schema property. object fields describe their nested
+// fields in Properties, array fields describe their elements in Items
 type Field struct {
-	Name string `json:"title"`
-	Type string `json:"type"`
-	Desc string `json:"description"`
+	Name       string           `json:"title"`
+	Type       FieldType        `json:"type"`
+	Desc       string           `json:"description"`
+	Format     string           `json:"format,omitempty"`
+	Enum       []any            `json:"enum,omitempty"`
+	Properties map[string]Field `json:"properties,omitempty"`
+	Required   []string         `json:"required,omitempty"`
+	Items      *Field           `json:"items,omitempty"`
 }
 
 type Schema struct {
@@ -32,6 +100,20 @@ type Schema struct {
 	Type       string           `json:"type"`
 }
 
+// ChangeDetection compares each result of a workflow with the previous one.
+// mark flags unchanged results and attaches a diff to changed ones, suppress
+// also stops unchanged results from reaching subscribers and webhooks
+type ChangeDetection struct {
+	Mode string `json:"mode"`
+	// KeyFields limits the comparison to these fields, empty compares
+	// the whole result
+	KeyFields []string `json:"key_fields"`
+}
+
+func (c ChangeDetection) Enabled() bool {
+	return c.Mode == ChangeDetectionMark || c.Mode == ChangeDetectionSuppress
+}
+
 type WorkflowRequestContext struct {
 	WorkflowName string `json:"workflow_name"`
 	Website      string `json:"website"`
@@ -41,12 +123,139 @@ type WorkflowRequestContext struct {
 }
 
 type Workflow struct {
-	Name       string                 `json:"name"`
-	ServiceUri string                 `json:"service_uri"`
-	Prompt     string                 `json:"prompt"`
-	Cron       string                 `json:"cron"`
-	Schema     Schema                 `json:"schema"`
-	Request    WorkflowRequestContext `json:"request"`
+	Name        string                 `json:"name"`
+	ServiceUri  string                 `json:"service_uri"`
+	ServiceId   string                 `json:"service_id"`
+	Prompt      string                 `json:"prompt"`
+	Cron        string                 `json:"cron"`
+	Status      string                 `json:"status"`
+	Source      string                 `json:"source"`
+	Tags        []string               `json:"tags"`
+	Changes     ChangeDetection        `json:"change_detection"`
+	DropInvalid bool                   `json:"drop_invalid"`
+	LastRunAt   *time.Time             `json:"last_run_at"`
+	NextRunAt   *time.Time             `json:"next_run_at"`
+	Schema      Schema                 `json:"schema"`
+	Request     WorkflowRequestContext `json:"request"`
+
+	// RunToken is sent with run requests, the worker rejects runs without
+	// it. it is never shown to users
+	RunToken string `json:"-"`
+
+	// results that failed schema validation. with DropInvalid they are
+	// not sent to subscribers or webhooks, otherwise they are tagged
+	ValidationFailures      int64      `json:"validation_failures"`
+	LastValidationError     string     `json:"last_validation_error"`
+	LastValidationFailureAt *time.Time `json:"last_validation_failure_at"`
+}
+
+// IsPaused reports whether the workflow was paused. workflows created
+// before statuses were tracked have an empty status and count as running
+func (w Workflow) IsPaused() bool {
+	return w.Status == WorkflowStatusPaused
+}
+
+// IsFailed reports whether the deployment of the workflow was lost
+func (w Workflow) IsFailed() bool {
+	return w.Status == WorkflowStatusFailed
+}
+
+func FindWorkflow(ctx context.Context, db *mongo.Client, databaseName string, workflowName string) (*Workflow, error) {
+	res := db.Database(databaseName).Collection("workflows").FindOne(ctx, bson.D{{Key: "name", Value: workflowName}})
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return nil, ErrNoWorkflowExists
+		}
+		return nil, res.Err()
+	}
+
+	workflow := Workflow{}
+	if err := res.Decode(&workflow); err != nil {
+		return nil, err
+	}
+
+	return &workflow, nil
+}
+
+func ListWorkflows(ctx context.Context, db *mongo.Client, databaseName string) ([]Workflow, error) {
+	cur, err := db.Database(databaseName).Collection("workflows").Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	workflows := []Workflow{}
+	if err := cur.All(ctx, &workflows); err != nil {
+		return nil, err
+	}
+
+	return workflows, nil
+}
+
+// updateWorkflowDocument replaces the deployment spec of a stored workflow,
+// leaving any other fields on the document untouched
+func updateWorkflowDocument(ctx context.Context, db *mongo.Client, databaseName string, workflow Workflow) error {
+	set := bson.D{
+		{Key: "serviceuri", Value: workflow.ServiceUri},
+		{Key: "serviceid", Value: workflow.ServiceId},
+		{Key: "prompt", Value: workflow.Prompt},
+		{Key: "cron", Value: workflow.Cron},
+		{Key: "tags", Value: workflow.Tags},
+		{Key: "changes", Value: workflow.Changes},
+		{Key: "dropinvalid", Value: workflow.DropInvalid},
+		{Key: "schema", Value: workflow.Schema},
+		{Key: "request", Value: workflow.Request},
+		{Key: "runtoken", Value: workflow.RunToken},
+	}
+
+	// updates from the ui or the api keep the source of the workflow
+	if workflow.Source != "" {
+		set = append(set, bson.E{Key: "source", Value: workflow.Source})
+	}
+
+	update := bson.D{{Key: "$set", Value: set}}
+
+	res, err := db.Database(databaseName).Collection("workflows").UpdateOne(ctx, bson.D{{Key: "name", Value: workflow.Name}}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNoWorkflowExists
+	}
+
+	return nil
+}
+
+func setWorkflowStatus(ctx context.Context, db *mongo.Client, databaseName string, workflowName string, status string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: status}}}}
+
+	res, err := db.Database(databaseName).Collection("workflows").UpdateOne(ctx, bson.D{{Key: "name", Value: workflowName}}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNoWorkflowExists
+	}
+
+	return nil
+}
+
+// SetWorkflowSource records where a workflow is managed from
+func SetWorkflowSource(ctx context.Context, db *mongo.Client, databaseName string, workflowName string, source string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "source", Value: source}}}}
+
+	res, err := db.Database(databaseName).Collection("workflows").UpdateOne(ctx, bson.D{{Key: "name", Value: workflowName}}, update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNoWorkflowExists
+	}
+
+	return nil
 }
 
 func generateWorkflowFromRequest(r *http.Request) (*Workflow, error) {
@@ -59,6 +268,12 @@ func generateWorkflowFromRequest(r *http.Request) (*Workflow, error) {
 	website := r.PostForm.Get("websiteInput")
 	cron := r.PostForm.Get("cronInput")
 	prompt := r.PostForm.Get("promptInput")
+	tags := ParseTags(r.PostForm.Get("tagsInput"))
+
+	changes, err := ParseChangeDetection(r.PostForm.Get("changeModeInput"), strings.Split(r.PostForm.Get("changeKeysInput"), ","))
+	if err != nil {
+		return nil, err
+	}
 	numberFields := r.PostForm.Get("numberFields")
 
 	fieldCounter, err := strconv.Atoi(numberFields)
@@ -66,41 +281,31 @@ func generateWorkflowFromRequest(r *http.Request) (*Workflow, error) {
 		return nil, err
 	}
 
-	schema := Schema{
-		Type:       "object",
-		Title:      "Generated Schema",
-		Properties: make(map[string]Field),
+	if err := ValidateCron(cron); err != nil {
+		return nil, err
 	}
 
-	for i := 0; i <= fieldCounter; i++ {
-		fieldName := r.PostForm.Get(fmt.Sprintf("fieldName_%d", i))
-		fieldType := r.PostForm.Get(fmt.Sprintf("fieldType_%d", i))
-		fieldDesc := r.PostForm.Get(fmt.Sprintf("fieldDesc_%d", i))
-
-		if fieldName == "" || fieldType == "" || fieldDesc == "" {
-			// the field was deleted
-			// the field counter is never udpated when a field is deleted
-			continue
-		}
-
-		field := Field{
-			Name: fieldName,
-			Type: fieldType,
-			Desc: fieldDesc,
-		}
-
-		schemaFieldName := strings.ReplaceAll(strings.ToLower(fieldName), " ", "-")
-
-		schema.Properties[schemaFieldName] = field
-		schema.Required = append(schema.Required, schemaFieldName)
+	// a json schema document replaces the fields added one by one
+	var schema Schema
+	if jsonSchema := strings.TrimSpace(r.PostForm.Get("jsonSchemaInput")); jsonSchema != "" {
+		schema, err = SchemaFromJSONSchema([]byte(jsonSchema))
+	} else {
+		schema, err = schemaFromForm(r.PostForm, fieldCounter)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	workflowName = strings.ReplaceAll(strings.ToLower(workflowName), " ", "_")
 	workflow := Workflow{
-		Name:   workflowName,
-		Prompt: prompt,
-		Schema: schema,
-		Cron:   cron,
+		Name:        workflowName,
+		Prompt:      prompt,
+		Schema:      schema,
+		Cron:        cron,
+		Status:      WorkflowStatusRunning,
+		Tags:        tags,
+		Changes:     changes,
+		DropInvalid: r.PostForm.Get("dropInvalidInput") == "on",
 		Request: WorkflowRequestContext{
 			WorkflowName: workflowName,
 			Website:      website,
@@ -0,0 +1,325 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/ferretcode/scavenger/internal/jsonpointer"
+)
+
+// keywords that change the shape of the data in ways the workflow schema
+// cannot describe. value constraints like minimum or pattern are ignored
+// since the worker only uses the schema as a description of its output
+var unsupportedJSONSchemaKeywords = []string{
+	"allOf", "anyOf", "oneOf", "not",
+	"if", "then", "else",
+	"patternProperties", "propertyNames", "unevaluatedProperties", "dependentSchemas", "dependentRequired",
+	"prefixItems", "contains", "unevaluatedItems",
+	"$dynamicRef", "$dynamicAnchor", "$recursiveRef",
+}
+
+// SchemaFromJSONSchema converts a JSON Schema document to a workflow
+// schema. it supports the subset of draft 2020-12 that maps onto workflow
+// fields: object and array types, properties, required, items, enum, const,
+// format and local $refs to $defs
+func SchemaFromJSONSchema(document []byte) (Schema, error) {
+	var root any
+	if err := json.Unmarshal(document, &root); err != nil {
+		return Schema{}, jsonSchemaError("", "document is not valid json: %s", err)
+	}
+
+	// inline documents can be sent as a json string holding the schema
+	if encoded, ok := root.(string); ok {
+		if err := json.Unmarshal([]byte(encoded), &root); err != nil {
+			return Schema{}, jsonSchemaError("", "document is not valid json: %s", err)
+		}
+	}
+
+	rootNode, ok := root.(map[string]any)
+	if !ok {
+		return Schema{}, jsonSchemaError("", "document must be a json object")
+	}
+
+	converter := jsonSchemaConverter{root: rootNode, resolving: make(map[string]bool)}
+
+	field, err := converter.convert("", "", rootNode)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	if field.Type != FieldTypeObject {
+		return Schema{}, jsonSchemaError("", "the root of the document must be an object")
+	}
+
+	if len(field.Properties) == 0 {
+		return Schema{}, jsonSchemaError("", "the root object has no properties")
+	}
+
+	title := "Generated Schema"
+	if field.Name != "" {
+		title = field.Name
+	}
+
+	schema := Schema{
+		Type:       "object",
+		Title:      title,
+		Properties: field.Properties,
+		Required:   field.Required,
+	}
+
+	if schema.Required == nil {
+		schema.Required = []string{}
+	}
+
+	if err := schema.Validate(); err != nil {
+		return Schema{}, err
+	}
+
+	return schema, nil
+}
+
+type jsonSchemaConverter struct {
+	root map[string]any
+	// refs being converted, a ref seen again while it is being converted
+	// is recursive
+	resolving map[string]bool
+}
+
+// convert converts the schema at path, a json pointer into the document.
+// key is the property name of the schema, used when it has no title
+func (c *jsonSchemaConverter) convert(path string, key string, node map[string]any) (Field, error) {
+	for _, keyword := range unsupportedJSONSchemaKeywords {
+		if _, ok := node[keyword]; ok {
+			return Field{}, jsonSchemaError(path, "%s is not supported", keyword)
+		}
+	}
+
+	if ref, ok := node["$ref"]; ok {
+		return c.convertRef(path, key, ref, node)
+	}
+
+	field := Field{Name: key}
+
+	if err := stringKeyword(path, node, "title", &field.Name); err != nil {
+		return Field{}, err
+	}
+	if field.Name == "" {
+		field.Name = key
+	}
+
+	if err := stringKeyword(path, node, "description", &field.Desc); err != nil {
+		return Field{}, err
+	}
+
+	if err := stringKeyword(path, node, "format", &field.Format); err != nil {
+		return Field{}, err
+	}
+
+	if field.Format != "" && !slices.Contains(SchemaFormats, field.Format) {
+		return Field{}, jsonSchemaError(path, "format %q is not supported, expected one of %s", field.Format, strings.Join(SchemaFormats, ", "))
+	}
+
+	if enum, ok := node["enum"]; ok {
+		values, ok := enum.([]any)
+		if !ok || len(values) == 0 {
+			return Field{}, jsonSchemaError(path, "enum must be a non empty array")
+		}
+		field.Enum = values
+	}
+
+	if value, ok := node["const"]; ok {
+		field.Enum = []any{value}
+	}
+
+	fieldType, err := c.fieldType(path, node)
+	if err != nil {
+		return Field{}, err
+	}
+
+	if !slices.Contains(FieldTypes, FieldType(fieldType)) {
+		return Field{}, jsonSchemaError(path, "type %q is not supported", fieldType)
+	}
+	field.Type = FieldType(fieldType)
+
+	if properties, ok := node["properties"]; ok {
+		propertyNodes, ok := properties.(map[string]any)
+		if !ok {
+			return Field{}, jsonSchemaError(path, "properties must be an object")
+		}
+
+		field.Properties = make(map[string]Field)
+
+		for _, name := range slices.Sorted(maps.Keys(propertyNodes)) {
+			propertyPath := path + "/properties/" + jsonpointer.Escape(name)
+
+			propertyNode, ok := propertyNodes[name].(map[string]any)
+			if !ok {
+				return Field{}, jsonSchemaError(propertyPath, "boolean schemas are not supported")
+			}
+
+			property, err := c.convert(propertyPath, name, propertyNode)
+			if err != nil {
+				return Field{}, err
+			}
+
+			field.Properties[name] = property
+		}
+	}
+
+	if required, ok := node["required"]; ok {
+		names, ok := required.([]any)
+		if !ok {
+			return Field{}, jsonSchemaError(path, "required must be an array of property names")
+		}
+
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok {
+				return Field{}, jsonSchemaError(path, "required must be an array of property names")
+			}
+			field.Required = append(field.Required, s)
+		}
+	}
+
+	if items, ok := node["items"]; ok {
+		switch itemsNode := items.(type) {
+		case map[string]any:
+			itemsField, err := c.convert(path+"/items", "", itemsNode)
+			if err != nil {
+				return Field{}, err
+			}
+			field.Items = &itemsField
+		case bool:
+			// items: true allows any element, which is the default
+			if !itemsNode {
+				return Field{}, jsonSchemaError(path+"/items", "boolean schemas are not supported")
+			}
+		default:
+			return Field{}, jsonSchemaError(path+"/items", "items must be a schema, tuples are not supported")
+		}
+	}
+
+	return field, nil
+}
+
+// convertRef converts a local reference into $defs or definitions. title
+// and description next to the $ref override the ones of the target
+func (c *jsonSchemaConverter) convertRef(path string, key string, ref any, node map[string]any) (Field, error) {
+	refPath, ok := ref.(string)
+	if !ok {
+		return Field{}, jsonSchemaError(path, "$ref must be a string")
+	}
+
+	var defsKeyword string
+	switch {
+	case strings.HasPrefix(refPath, "#/$defs/"):
+		defsKeyword = "$defs"
+	case strings.HasPrefix(refPath, "#/definitions/"):
+		defsKeyword = "definitions"
+	default:
+		return Field{}, jsonSchemaError(path, "$ref %q is not supported, only references to #/$defs are", refPath)
+	}
+
+	if c.resolving[refPath] {
+		return Field{}, jsonSchemaError(path, "recursive $ref %q is not supported", refPath)
+	}
+
+	defs, _ := c.root[defsKeyword].(map[string]any)
+	name := jsonpointer.Unescape(strings.TrimPrefix(refPath, "#/"+defsKeyword+"/"))
+
+	target, ok := defs[name].(map[string]any)
+	if !ok {
+		return Field{}, jsonSchemaError(path, "$ref %q does not point to a schema", refPath)
+	}
+
+	c.resolving[refPath] = true
+	field, err := c.convert(strings.TrimPrefix(refPath, "#"), key, target)
+	delete(c.resolving, refPath)
+
+	if err != nil {
+		return Field{}, err
+	}
+
+	if err := stringKeyword(path, node, "title", &field.Name); err != nil {
+		return Field{}, err
+	}
+
+	if err := stringKeyword(path, node, "description", &field.Desc); err != nil {
+		return Field{}, err
+	}
+
+	return field, nil
+}
+
+// fieldType reads the type of a schema. nullable types like
+// ["string", "null"] use the non null type, and a missing type is inferred
+// from the other keywords
+func (c *jsonSchemaConverter) fieldType(path string, node map[string]any) (string, error) {
+	switch t := node["type"].(type) {
+	case string:
+		return t, nil
+	case []any:
+		types := []string{}
+		for _, value := range t {
+			s, ok := value.(string)
+			if !ok {
+				return "", jsonSchemaError(path, "type must be a string or an array of strings")
+			}
+			if s != "null" {
+				types = append(types, s)
+			}
+		}
+
+		if len(types) != 1 {
+			return "", jsonSchemaError(path, "type unions other than a type and null are not supported")
+		}
+		return types[0], nil
+	case nil:
+		if _, ok := node["type"]; ok {
+			break
+		}
+
+		if _, ok := node["properties"]; ok {
+			return "object", nil
+		}
+		if _, ok := node["items"]; ok {
+			return "array", nil
+		}
+		if values, ok := node["enum"].([]any); ok && len(values) > 0 {
+			return jsonTypeOf(values[0]), nil
+		}
+		if value, ok := node["const"]; ok {
+			return jsonTypeOf(value), nil
+		}
+
+		return "", jsonSchemaError(path, "type is required")
+	}
+
+	return "", jsonSchemaError(path, "type must be a string or an array of strings")
+}
+
+func stringKeyword(path string, node map[string]any, keyword string, dst *string) error {
+	value, ok := node[keyword]
+	if !ok {
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return jsonSchemaError(path, "%s must be a string", keyword)
+	}
+
+	*dst = s
+	return nil
+}
+
+func jsonSchemaError(path string, format string, args ...any) error {
+	if path == "" {
+		path = "/"
+	}
+
+	return &ValidationError{Message: fmt.Sprintf("json schema %s: %s", path, fmt.Sprintf(format, args...))}
+}
@@ -0,0 +1,207 @@
+package infrastructure_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+)
+
+func TestSchemaFromJSONSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     infrastructure.Schema
+		// a substring of the validation error, empty when the document converts
+		wantErr string
+	}{
+		{
+			name: "properties and required",
+			document: `{
+				"title": "Products",
+				"type": "object",
+				"properties": {
+					"name": {"type": "string", "description": "product name"},
+					"price": {"type": "number"}
+				},
+				"required": ["name"]
+			}`,
+			want: infrastructure.Schema{
+				Type:  "object",
+				Title: "Products",
+				Properties: map[string]infrastructure.Field{
+					"name":  {Name: "name", Type: infrastructure.FieldTypeString, Desc: "product name"},
+					"price": {Name: "price", Type: infrastructure.FieldTypeNumber},
+				},
+				Required: []string{"name"},
+			},
+		},
+		{
+			name:     "inline document in a json string",
+			document: `"{\"properties\": {\"name\": {\"type\": \"string\"}}}"`,
+			want: infrastructure.Schema{
+				Type:       "object",
+				Title:      "Generated Schema",
+				Properties: map[string]infrastructure.Field{"name": {Name: "name", Type: infrastructure.FieldTypeString}},
+				Required:   []string{},
+			},
+		},
+		{
+			name: "ref to defs with overrides",
+			document: `{
+				"type": "object",
+				"properties": {
+					"price": {"$ref": "#/$defs/money", "description": "the sale price"},
+					"list": {"$ref": "#/definitions/money"}
+				},
+				"$defs": {"money": {"title": "Money", "type": "number", "description": "an amount"}},
+				"definitions": {"money": {"type": "number"}}
+			}`,
+			want: infrastructure.Schema{
+				Type:  "object",
+				Title: "Generated Schema",
+				Properties: map[string]infrastructure.Field{
+					"price": {Name: "Money", Type: infrastructure.FieldTypeNumber, Desc: "the sale price"},
+					"list":  {Name: "list", Type: infrastructure.FieldTypeNumber},
+				},
+				Required: []string{},
+			},
+		},
+		{
+			name: "ref to an escaped defs name",
+			document: `{
+				"properties": {"size": {"$ref": "#/$defs/a~1b"}},
+				"$defs": {"a/b": {"type": "integer"}}
+			}`,
+			want: infrastructure.Schema{
+				Type:       "object",
+				Title:      "Generated Schema",
+				Properties: map[string]infrastructure.Field{"size": {Name: "size", Type: infrastructure.FieldTypeInteger}},
+				Required:   []string{},
+			},
+		},
+		{
+			name: "the same ref used twice is not recursive",
+			document: `{
+				"properties": {"a": {"$ref": "#/$defs/n"}, "b": {"$ref": "#/$defs/n"}},
+				"$defs": {"n": {"type": "number"}}
+			}`,
+			want: infrastructure.Schema{
+				Type:  "object",
+				Title: "Generated Schema",
+				Properties: map[string]infrastructure.Field{
+					"a": {Name: "a", Type: infrastructure.FieldTypeNumber},
+					"b": {Name: "b", Type: infrastructure.FieldTypeNumber},
+				},
+				Required: []string{},
+			},
+		},
+		{
+			name: "nullable unions use the non null type",
+			document: `{
+				"properties": {
+					"note": {"type": ["string", "null"]},
+					"tags": {"type": ["null", "array"], "items": {"type": "string"}}
+				}
+			}`,
+			want: infrastructure.Schema{
+				Type:  "object",
+				Title: "Generated Schema",
+				Properties: map[string]infrastructure.Field{
+					"note": {Name: "note", Type: infrastructure.FieldTypeString},
+					"tags": {Name: "tags", Type: infrastructure.FieldTypeArray, Items: &infrastructure.Field{Type: infrastructure.FieldTypeString}},
+				},
+				Required: []string{},
+			},
+		},
+		{
+			name: "const and enum infer the type",
+			document: `{
+				"properties": {
+					"kind": {"const": "product"},
+					"size": {"enum": [1, 2, 3]}
+				}
+			}`,
+			want: infrastructure.Schema{
+				Type:  "object",
+				Title: "Generated Schema",
+				Properties: map[string]infrastructure.Field{
+					"kind": {Name: "kind", Type: infrastructure.FieldTypeString, Enum: []any{"product"}},
+					"size": {Name: "size", Type: infrastructure.FieldTypeNumber, Enum: []any{float64(1), float64(2), float64(3)}},
+				},
+				Required: []string{},
+			},
+		},
+		{
+			name: "recursive ref",
+			document: `{
+				"properties": {"tree": {"$ref": "#/$defs/node"}},
+				"$defs": {"node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}}}}
+			}`,
+			wantErr: `/$defs/node/properties/children/items: recursive $ref "#/$defs/node" is not supported`,
+		},
+		{
+			name:     "remote ref",
+			document: `{"properties": {"a": {"$ref": "https://example.com/schema.json"}}}`,
+			wantErr:  "/properties/a: $ref \"https://example.com/schema.json\" is not supported",
+		},
+		{
+			name:     "missing ref target",
+			document: `{"properties": {"a": {"$ref": "#/$defs/missing"}}}`,
+			wantErr:  "does not point to a schema",
+		},
+		{
+			name:     "unions of two types",
+			document: `{"properties": {"a": {"type": ["string", "number"]}}}`,
+			wantErr:  "type unions other than a type and null are not supported",
+		},
+		{
+			name:     "unsupported keyword",
+			document: `{"properties": {"a": {"anyOf": [{"type": "string"}]}}}`,
+			wantErr:  "/properties/a: anyOf is not supported",
+		},
+		{
+			name:     "root is not an object",
+			document: `{"type": "array", "items": {"type": "string"}}`,
+			wantErr:  "the root of the document must be an object",
+		},
+		{
+			name:     "root without properties",
+			document: `{"type": "object"}`,
+			wantErr:  "the root object has no properties",
+		},
+		{
+			name:     "not json",
+			document: `{`,
+			wantErr:  "document is not valid json",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := infrastructure.SchemaFromJSONSchema([]byte(test.document))
+
+			if test.wantErr != "" {
+				var validationErr *infrastructure.ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("SchemaFromJSONSchema() error = %v, want a validation error", err)
+				}
+
+				if !strings.Contains(validationErr.Message, test.wantErr) {
+					t.Errorf("SchemaFromJSONSchema() error = %q, want it to contain %q", validationErr.Message, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("SchemaFromJSONSchema() returned an error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SchemaFromJSONSchema() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +29,8 @@ type LocalServiceProvider struct {
 	dockerClient     *client.Client
 	runningWorkflows map[string]string
 	mu               sync.Mutex
+
+	deleteHooks
 }
 
 func NewLocalServiceProvider(config *types.ScavengerConfig, db *mongo.Client, ctx context.Context, logger *slog.Logger) (*LocalServiceProvider, error) {
@@ -82,31 +86,35 @@ func NewLocalServiceProvider(config *types.ScavengerConfig, db *mongo.Client, ct
 }
 
 func (l *LocalServiceProvider) CheckWorkflowExists(workflowName string) (bool, error) {
+	deployed, err := l.DeployedWorkflows()
+	if err != nil {
+		return false, err
+	}
+
+	return deployed[workflowName], nil
+}
+
+// DeployedWorkflows returns the names of the workflows that have a worker
+// container, stopped or not
+func (l *LocalServiceProvider) DeployedWorkflows() (map[string]bool, error) {
 	filterArgs := filters.NewArgs()
-	filterArgs.Add("status", "running")
 	filterArgs.Add("label", "app.scavenger")
 
-	containers, err := l.dockerClient.ContainerList(l.ctx, container.ListOptions{Filters: filterArgs})
+	// paused workflows keep their stopped container around, so they still exist
+	containers, err := l.dockerClient.ContainerList(l.ctx, container.ListOptions{All: true, Filters: filterArgs})
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	deployed := make(map[string]bool)
 
 	for _, c := range containers {
-		if c.Labels == nil {
-			continue
+		if workflowName := c.Labels["app.scavenger.workflow"]; workflowName != "" {
+			deployed[workflowName] = true
 		}
-
-		runningWorkflowName, ok := c.Labels["app.scavenger.workflow"]
-		if ok && runningWorkflowName == workflowName {
-			return true, nil
-		}
-
 	}
 
-	return false, nil
+	return deployed, nil
 }
 
 func (l *LocalServiceProvider) Close() error {
@@ -122,9 +130,7 @@ func (l *LocalServiceProvider) CreateWorkflowFromConfig(workflow Workflow) error
 		return err
 	}
 
-	err = l.createWorkflow(workflow, string(schemaBytes))
-
-	return nil
+	return l.createWorkflow(workflow, string(schemaBytes))
 }
 
 func (l *LocalServiceProvider) CreateWorkflow(w http.ResponseWriter, r *http.Request) error {
@@ -143,6 +149,8 @@ func (l *LocalServiceProvider) CreateWorkflow(w http.ResponseWriter, r *http.Req
 		return err
 	}
 
+	http.Redirect(w, r, "/workflows", http.StatusSeeOther)
+
 	return nil
 }
 
@@ -158,49 +166,29 @@ func (l *LocalServiceProvider) DeleteWorkflow(w http.ResponseWriter, r *http.Req
 		return fmt.Errorf("workflowName is required")
 	}
 
-	l.mu.Lock()
-	containerID, ok := l.runningWorkflows[workflowName]
-	l.mu.Unlock()
-
-	if ok {
-		l.logger.Info("stopping docker container for workflow", "workflowName", workflowName, "container-id", containerID)
-
-		stopTimeout := 10 * time.Second
-		timeoutSeconds := int(stopTimeout.Seconds())
-
-		stopErr := l.dockerClient.ContainerStop(l.ctx, containerID, container.StopOptions{Timeout: &timeoutSeconds})
-		if stopErr != nil {
-			l.logger.Error("failed to stop docker container gracefully", "container-id", containerID, "err", stopErr)
-		} else {
-			l.logger.Info("docker container stopped gracefully", "container-id", containerID)
-		}
-
-		l.logger.Info("removing docker container", "workflowName", workflowName, "container-id", containerID)
+	err = l.DeleteWorkflowByName(workflowName)
+	if err != nil {
+		return err
+	}
 
-		removeOptions := container.RemoveOptions{
-			RemoveVolumes: true,
-			Force:         true,
-		}
-		removeErr := l.dockerClient.ContainerRemove(l.ctx, containerID, removeOptions)
-		if removeErr != nil {
-			l.logger.Error("failed to remove docker container", "container-id", containerID, "err", removeErr)
-		} else {
-			l.logger.Info("successfully removed docker container", "container-id", containerID)
-		}
+	http.Redirect(w, r, "http://localhost:3000/workflows", http.StatusSeeOther)
 
-		l.mu.Lock()
-		delete(l.runningWorkflows, workflowName)
-		l.mu.Unlock()
+	return nil
+}
 
-	} else {
-		l.logger.Warn("workflow not found in running workflows map for deletion (container likely not started by this provider instance or already stopped)", "workflowName", workflowName)
+func (l *LocalServiceProvider) DeleteWorkflowByName(workflowName string) error {
+	// paused workflows are not in the running map but keep their stopped
+	// container, so containers are looked up by label
+	err := l.removeWorkflowContainers(workflowName)
+	if err != nil {
+		return err
 	}
 
 	bsonFilter := bson.D{{Key: "name", Value: workflowName}}
 	result, dbErr := l.db.Database(l.Config.DatabaseName).Collection("workflows").DeleteOne(l.ctx, bsonFilter)
 	if dbErr != nil {
 		l.logger.Error("failed to delete workflow from DB", "name", workflowName, "err", dbErr)
-		return err
+		return dbErr
 	}
 
 	if result.DeletedCount == 0 {
@@ -209,11 +197,181 @@ func (l *LocalServiceProvider) DeleteWorkflow(w http.ResponseWriter, r *http.Req
 		l.logger.Info("workflow deleted from DB", "name", workflowName)
 	}
 
-	http.Redirect(w, r, "http://localhost:3000/workflows", http.StatusSeeOther)
+	return l.workflowDeleted(workflowName)
+}
+
+func (l *LocalServiceProvider) UpdateWorkflow(w http.ResponseWriter, r *http.Request) error {
+	workflow, err := generateWorkflowFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	err = l.UpdateWorkflowFromConfig(*workflow)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/workflows", http.StatusSeeOther)
+
+	return nil
+}
+
+func (l *LocalServiceProvider) UpdateWorkflowFromConfig(workflow Workflow) error {
+	existing, err := FindWorkflow(l.ctx, l.db, l.Config.DatabaseName, workflow.Name)
+	if err != nil {
+		return err
+	}
+
+	schemaBytes, err := json.Marshal(workflow.Schema)
+	if err != nil {
+		return err
+	}
+
+	// reuse the published port so subscribers can keep using the same uri
+	hostPort := ""
+	if serviceUri, err := url.Parse(existing.ServiceUri); err == nil {
+		hostPort = serviceUri.Port()
+	}
+
+	workflow.RunToken = existing.RunToken
+	if err := ensureRunToken(&workflow); err != nil {
+		return err
+	}
+
+	// the old containers are kept stopped until the new one runs, so a
+	// failed update can bring the workflow back as it was
+	previous, err := l.setAsideContainers(workflow.Name)
+	if err != nil {
+		return err
+	}
+
+	var containerID string
+	if existing.IsPaused() {
+		// starting the container would run a scrape, a paused workflow only
+		// gets its container started on resume
+		containerID, err = l.createContainer(workflow, string(schemaBytes), hostPort)
+	} else {
+		containerID, hostPort, err = l.startContainer(workflow, string(schemaBytes), hostPort)
+	}
+	if err != nil {
+		l.restoreContainers(*existing, previous)
+		return err
+	}
+
+	for _, previousID := range previous {
+		err := l.removeContainer(previousID)
+		if err != nil {
+			l.logger.Error("failed to remove previous container of updated workflow", "workflow-name", workflow.Name, "container-id", previousID, "err", err)
+		}
+	}
+
+	if !existing.IsPaused() {
+		l.mu.Lock()
+		l.runningWorkflows[workflow.Name] = containerID
+		l.mu.Unlock()
+	}
+
+	// a created container without a fixed port gets one when it is resumed
+	workflow.ServiceUri = existing.ServiceUri
+	if hostPort != "" {
+		workflow.ServiceUri = fmt.Sprintf("http://localhost:%s", hostPort)
+	}
+	workflow.ServiceId = existing.ServiceId
+
+	err = updateWorkflowDocument(l.ctx, l.db, l.Config.DatabaseName, workflow)
+	if err != nil {
+		// the previous containers are gone, the stored spec no longer
+		// matches the one deployed
+		l.markWorkflowFailed(workflow.Name)
+		return fmt.Errorf("failed to save updated workflow %s to database: %w", workflow.Name, err)
+	}
+
+	if existing.IsFailed() {
+		err := setWorkflowStatus(l.ctx, l.db, l.Config.DatabaseName, workflow.Name, WorkflowStatusRunning)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.logger.Info("workflow updated", "workflow-name", workflow.Name, "container-id", containerID)
 
 	return nil
 }
 
+func (l *LocalServiceProvider) PauseWorkflow(workflowName string) error {
+	_, err := FindWorkflow(l.ctx, l.db, l.Config.DatabaseName, workflowName)
+	if err != nil {
+		return err
+	}
+
+	containerIDs, err := l.findContainers(workflowName)
+	if err != nil {
+		return err
+	}
+
+	for _, containerID := range containerIDs {
+		l.logger.Info("stopping docker container to pause workflow", "workflow-name", workflowName, "container-id", containerID)
+
+		err := l.stopContainer(containerID)
+		if err != nil {
+			return fmt.Errorf("failed to stop container %s for workflow %s: %w", containerID, workflowName, err)
+		}
+	}
+
+	l.mu.Lock()
+	delete(l.runningWorkflows, workflowName)
+	l.mu.Unlock()
+
+	return setWorkflowStatus(l.ctx, l.db, l.Config.DatabaseName, workflowName, WorkflowStatusPaused)
+}
+
+func (l *LocalServiceProvider) ResumeWorkflow(workflowName string) error {
+	workflow, err := FindWorkflow(l.ctx, l.db, l.Config.DatabaseName, workflowName)
+	if err != nil {
+		return err
+	}
+
+	// containers set aside by a failed update carry the same label, only
+	// the one with the workflow's container name is its worker
+	containerID, err := l.findWorkflowContainer(workflowName)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Info("starting docker container to resume workflow", "workflow-name", workflowName, "container-id", containerID)
+
+	err = l.dockerClient.ContainerStart(l.ctx, containerID, container.StartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to start container %s for workflow %s: %w", containerID, workflowName, err)
+	}
+
+	l.mu.Lock()
+	l.runningWorkflows[workflowName] = containerID
+	l.mu.Unlock()
+
+	// a container without a fixed host port can be given a new one when it restarts
+	inspectResp, err := l.dockerClient.ContainerInspect(l.ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	bindings := inspectResp.NetworkSettings.Ports["8765/tcp"]
+	if len(bindings) > 0 {
+		serviceUri := fmt.Sprintf("http://localhost:%s", bindings[0].HostPort)
+
+		if serviceUri != workflow.ServiceUri {
+			workflow.ServiceUri = serviceUri
+
+			err := updateWorkflowDocument(l.ctx, l.db, l.Config.DatabaseName, *workflow)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return setWorkflowStatus(l.ctx, l.db, l.Config.DatabaseName, workflowName, WorkflowStatusRunning)
+}
+
 func (l *LocalServiceProvider) GetRunningWorkflows() (int, error) {
 	l.mu.Lock()
 	count := len(l.runningWorkflows)
@@ -231,37 +389,132 @@ func (l *LocalServiceProvider) createWorkflow(workflow Workflow, schemaBytes str
 	}
 
 	if exists {
-		l.logger.Info("workflow already exists, skipping creation", "workflow-name", workflow.Name)
-		return nil // no-op because workflow already exists
+		_, err := FindWorkflow(l.ctx, l.db, l.Config.DatabaseName, workflow.Name)
+		if err == nil {
+			l.logger.Info("workflow already exists, skipping creation", "workflow-name", workflow.Name)
+			return nil // no-op because workflow already exists
+		}
+		if err != ErrNoWorkflowExists {
+			return err
+		}
+
+		// a container without a database record is left behind when saving
+		// the workflow failed, it is replaced by the new one
+		l.logger.Warn("removing containers of workflow without a database record", "workflow-name", workflow.Name)
+
+		err = l.removeWorkflowContainers(workflow.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureRunToken(&workflow)
+	if err != nil {
+		return err
+	}
+
+	containerID, hostPort, err := l.startContainer(workflow, schemaBytes, "")
+	if err != nil {
+		return err
+	}
+
+	workflow.ServiceUri = fmt.Sprintf("http://localhost:%s", hostPort)
+
+	_, err = l.db.Database(l.Config.DatabaseName).Collection("workflows").InsertOne(l.ctx, workflow)
+	if err != nil {
+		l.logger.Error("failed to insert workflow into DB after starting container", "workflow-name", workflow.Name, "container-id", containerID, "err", err)
+		l.logger.Warn("attempting to stop/remove container due to db insertion failure", "container-id", containerID)
+
+		l.rollbackContainer(containerID, "db insertion failure")
+
+		return fmt.Errorf("failed to save workflow to database after starting container %s: %w", containerID, err)
+	}
+
+	l.mu.Lock()
+	l.runningWorkflows[workflow.Name] = containerID
+	l.mu.Unlock()
+
+	return nil
+}
+
+// startContainer creates and starts a worker container for the workflow. if
+// hostPort is empty docker picks a free port, otherwise the given port is
+// reused so the workflow keeps its service uri
+func (l *LocalServiceProvider) startContainer(workflow Workflow, schemaBytes string, hostPort string) (string, string, error) {
+	containerPort, err := nat.NewPort("tcp", "8765")
+	if err != nil {
+		l.logger.Error("failed to create nat.Port for 8765/tcp", "err", err)
+		return "", "", err
 	}
 
-	imageName := "sthanguy/scavenger-scraper"
-	if l.Config.WorkerImage != "" {
-		imageName = l.Config.WorkerImage
+	containerID, err := l.createContainer(workflow, schemaBytes, hostPort)
+	if err != nil {
+		return "", "", err
 	}
 
+	err = l.dockerClient.ContainerStart(l.ctx, containerID, container.StartOptions{})
+	if err != nil {
+		l.logger.Error("failed to start docker container", "workflowName", workflow.Name, "container-id", containerID, "err", err)
+
+		removeErr := l.dockerClient.ContainerRemove(l.ctx, containerID, container.RemoveOptions{Force: true})
+		if removeErr != nil {
+			l.logger.Error("failed to remove container after start failure", "container-id", containerID, "err", removeErr)
+		}
+
+		return "", "", fmt.Errorf("failed to start docker container for workflow %s (ID: %s): %w", workflow.Name, containerID, err)
+	}
+
+	l.logger.Info("docker container started", "workflow-name", workflow.Name, "container-id", containerID)
+
+	inspectResp, err := l.dockerClient.ContainerInspect(l.ctx, containerID)
+	if err != nil {
+		l.logger.Error("failed to inspect docker container after start", "workflowName", workflow.Name, "container-id", containerID, "err", err)
+		l.logger.Warn("Attempting to stop/remove container due to inspection failure", "container-id", containerID)
+
+		l.rollbackContainer(containerID, "inspection failure")
+
+		return "", "", err
+	}
+
+	bindings, ok := inspectResp.NetworkSettings.Ports[containerPort]
+	if !ok || len(bindings) == 0 {
+		l.logger.Error("port binding not found for container", "workflowName", workflow.Name, "container-id", containerID, "containerPort", containerPort)
+
+		l.logger.Warn("Attempting to stop/remove container due to missing port binding", "container-id", containerID)
+
+		l.rollbackContainer(containerID, "missing port binding")
+
+		return "", "", fmt.Errorf("port binding not found for container %s", containerID)
+	}
+
+	return containerID, bindings[0].HostPort, nil
+}
+
+// createContainer creates the worker container for the workflow without
+// starting it
+func (l *LocalServiceProvider) createContainer(workflow Workflow, schemaBytes string, hostPort string) (string, error) {
+	imageName := workerImage(l.Config)
+
+	// the published worker image still reads CRONTAB at startup, newer
+	// workers ignore it when the control plane schedules their runs
 	envVars := []string{
 		fmt.Sprintf("CRONTAB=%s", workflow.Cron),
+		"CONTROL_PLANE_SCHEDULING=true",
 		fmt.Sprintf("GEMINI_API_KEY=%s", l.Config.GeminiApiKey),
 		fmt.Sprintf("SCHEMA=%s", string(schemaBytes)),
 		fmt.Sprintf("PROMPT=%s", workflow.Prompt),
 		fmt.Sprintf("WEBPAGE_URL=%s", workflow.Request.Website),
 		fmt.Sprintf("PORT=%s", "8765"),
-	}
-
-	containerPort, err := nat.NewPort("tcp", "8765")
-	if err != nil {
-		l.logger.Error("failed to create nat.Port for 8765/tcp", "err", err)
-		return err
+		fmt.Sprintf("RUN_TOKEN=%s", workflow.RunToken),
 	}
 
 	portBindings := nat.PortMap{
-		"8765/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: ""}},
+		"8765/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}},
 	}
 
 	memoryLimitBytes := int64(1.5 * 1024 * 1024 * 1024)
 
-	containerName := fmt.Sprintf("scavenger-workflow-%s", strings.ReplaceAll(workflow.Name, " ", "-"))
+	containerName := containerName(workflow.Name)
 
 	labels := map[string]string{
 		"app.scavenger":          "workflow-worker",
@@ -293,94 +546,197 @@ func (l *LocalServiceProvider) createWorkflow(workflow Workflow, schemaBytes str
 		containerName,
 	)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	l.logger.Info("docker container created", "workflow-name", workflow.Name, "container-name", containerName, "container-id", resp.ID)
 
-	err = l.dockerClient.ContainerStart(l.ctx, resp.ID, container.StartOptions{})
-	if err != nil {
-		l.logger.Error("failed to start docker container", "workflowName", workflow.Name, "container-id", resp.ID, "err", err)
+	return resp.ID, nil
+}
 
-		removeErr := l.dockerClient.ContainerRemove(l.ctx, resp.ID, container.RemoveOptions{Force: true})
-		if removeErr != nil {
-			l.logger.Error("failed to remove container after start failure", "container-id", resp.ID, "err", removeErr)
-		}
+func containerName(workflowName string) string {
+	return fmt.Sprintf("scavenger-workflow-%s", strings.ReplaceAll(workflowName, " ", "-"))
+}
+
+func shortContainerID(containerID string) string {
+	if len(containerID) > 12 {
+		return containerID[:12]
+	}
+	return containerID
+}
 
-		return fmt.Errorf("failed to start docker container for workflow %s (ID: %s): %w", workflow.Name, resp.ID, err)
+// findContainers returns the ids of every container labelled with the
+// workflow name, including stopped ones
+func (l *LocalServiceProvider) findContainers(workflowName string) ([]string, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", fmt.Sprintf("app.scavenger.workflow=%s", workflowName))
+
+	containers, err := l.dockerClient.ContainerList(l.ctx, container.ListOptions{All: true, Filters: filterArgs})
+	if err != nil {
+		return nil, err
 	}
 
-	l.logger.Info("docker container started", "workflow-name", workflow.Name, "container-id", resp.ID)
+	var containerIDs []string
+	for _, c := range containers {
+		containerIDs = append(containerIDs, c.ID)
+	}
+
+	return containerIDs, nil
+}
+
+// findWorkflowContainer returns the id of the container named after the
+// workflow, leaving out containers set aside by an update
+func (l *LocalServiceProvider) findWorkflowContainer(workflowName string) (string, error) {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("label", fmt.Sprintf("app.scavenger.workflow=%s", workflowName))
 
-	inspectResp, err := l.dockerClient.ContainerInspect(l.ctx, resp.ID)
+	containers, err := l.dockerClient.ContainerList(l.ctx, container.ListOptions{All: true, Filters: filterArgs})
 	if err != nil {
-		l.logger.Error("failed to inspect docker container after start", "workflowName", workflow.Name, "container-id", resp.ID, "err", err)
-		l.logger.Warn("Attempting to stop/remove container due to inspection failure", "container-id", resp.ID)
+		return "", err
+	}
 
-		stopTimeout := 10 * time.Second
-		timeout := int(stopTimeout.Seconds())
-		stopErr := l.dockerClient.ContainerStop(l.ctx, resp.ID, container.StopOptions{Timeout: &timeout})
+	name := "/" + containerName(workflowName)
 
-		if stopErr != nil {
-			l.logger.Error("failed to stop container during rollback after inspection failure", "container-id", resp.ID, "err", stopErr)
+	for _, c := range containers {
+		if slices.Contains(c.Names, name) {
+			return c.ID, nil
 		}
+	}
 
-		removeErr := l.dockerClient.ContainerRemove(l.ctx, resp.ID, container.RemoveOptions{Force: true})
-		if removeErr != nil {
-			l.logger.Error("failed to remove container during rollback after inspection failure", "container-id", resp.ID, "err", removeErr)
+	return "", fmt.Errorf("no container found for workflow %s", workflowName)
+}
+
+// setAsideContainers stops the containers of the workflow and renames them
+// so a replacement can take the container name and host port
+func (l *LocalServiceProvider) setAsideContainers(workflowName string) ([]string, error) {
+	containerIDs, err := l.findContainers(workflowName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, containerID := range containerIDs {
+		err := l.stopContainer(containerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stop container %s for workflow %s: %w", containerID, workflowName, err)
 		}
 
-		return err
+		err = l.dockerClient.ContainerRename(l.ctx, containerID, fmt.Sprintf("%s-previous-%s", containerName(workflowName), shortContainerID(containerID)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename container %s for workflow %s: %w", containerID, workflowName, err)
+		}
 	}
 
-	bindings, ok := inspectResp.NetworkSettings.Ports[containerPort]
-	if !ok || len(bindings) == 0 {
-		l.logger.Error("port binding not found for container", "workflowName", workflow.Name, "container-id", resp.ID, "containerPort", containerPort)
+	l.mu.Lock()
+	delete(l.runningWorkflows, workflowName)
+	l.mu.Unlock()
+
+	return containerIDs, nil
+}
 
-		l.logger.Warn("Attempting to stop/remove container due to missing port binding", "container-id", resp.ID)
+// restoreContainers brings back the container set aside by a failed update.
+// if that fails too the workflow is marked as failed, its record no longer
+// points at a running worker
+func (l *LocalServiceProvider) restoreContainers(workflow Workflow, containerIDs []string) {
+	err := l.restoreContainer(workflow, containerIDs)
+	if err == nil {
+		return
+	}
 
-		stopTimeout := 10 * time.Second
-		timeout := int(stopTimeout.Seconds())
-		stopErr := l.dockerClient.ContainerStop(l.ctx, resp.ID, container.StopOptions{Timeout: &timeout})
+	l.logger.Error("failed to restore workflow after failed update", "workflow-name", workflow.Name, "err", err)
 
-		if stopErr != nil {
-			l.logger.Error("failed to stop container during rollback after missing port binding", "container-id", resp.ID, "err", stopErr)
-		}
-		removeErr := l.dockerClient.ContainerRemove(l.ctx, resp.ID, container.RemoveOptions{Force: true})
+	l.markWorkflowFailed(workflow.Name)
+}
 
-		if removeErr != nil {
-			l.logger.Error("failed to remove container during rollback after missing port binding", "container-id", resp.ID, "err", removeErr)
-		}
+// markWorkflowFailed records that the deployment of the workflow no longer
+// matches its record
+func (l *LocalServiceProvider) markWorkflowFailed(workflowName string) {
+	err := setWorkflowStatus(l.ctx, l.db, l.Config.DatabaseName, workflowName, WorkflowStatusFailed)
+	if err != nil {
+		l.logger.Error("failed to mark workflow as failed", "workflow-name", workflowName, "err", err)
+	}
+}
 
+func (l *LocalServiceProvider) restoreContainer(workflow Workflow, containerIDs []string) error {
+	if len(containerIDs) == 0 {
+		return fmt.Errorf("no previous container found for workflow %s", workflow.Name)
+	}
+
+	containerID := containerIDs[0]
+
+	err := l.dockerClient.ContainerRename(l.ctx, containerID, containerName(workflow.Name))
+	if err != nil {
 		return err
 	}
 
-	hostPort := bindings[0].HostPort
+	if workflow.IsPaused() {
+		return nil
+	}
 
-	workflow.ServiceUri = fmt.Sprintf("http://localhost:%s", hostPort)
+	err = l.dockerClient.ContainerStart(l.ctx, containerID, container.StartOptions{})
+	if err != nil {
+		return err
+	}
 
-	_, err = l.db.Database(l.Config.DatabaseName).Collection("workflows").InsertOne(l.ctx, workflow)
+	l.mu.Lock()
+	l.runningWorkflows[workflow.Name] = containerID
+	l.mu.Unlock()
+
+	l.logger.Info("restored previous container after failed update", "workflow-name", workflow.Name, "container-id", containerID)
+
+	return nil
+}
+
+// removeWorkflowContainers stops and removes every container of the
+// workflow, running or not
+func (l *LocalServiceProvider) removeWorkflowContainers(workflowName string) error {
+	containerIDs, err := l.findContainers(workflowName)
 	if err != nil {
-		l.logger.Error("failed to insert workflow into DB after starting container", "workflow-name", workflow.Name, "container-id", resp.ID, "err", err)
-		l.logger.Warn("attempting to stop/remove container due to db insertion failure", "container-id", resp.ID)
+		return err
+	}
 
-		stopTimeout := 10
-		stopErr := l.dockerClient.ContainerStop(l.ctx, resp.ID, container.StopOptions{Timeout: &stopTimeout})
-		if stopErr != nil {
-			l.logger.Error("failed to stop container during rollback", "container-id", resp.ID, "err", stopErr)
-		}
+	for _, containerID := range containerIDs {
+		l.logger.Info("removing docker container for workflow", "workflow-name", workflowName, "container-id", containerID)
 
-		removeErr := l.dockerClient.ContainerRemove(l.ctx, resp.ID, container.RemoveOptions{Force: true})
-		if removeErr != nil {
-			l.logger.Error("failed to remove container during rollback", "container-id", resp.ID, "err", removeErr)
+		err := l.removeContainer(containerID)
+		if err != nil {
+			return fmt.Errorf("failed to remove container %s for workflow %s: %w", containerID, workflowName, err)
 		}
-
-		return fmt.Errorf("failed to save workflow to database after starting container %s: %w", resp.ID, err)
 	}
 
 	l.mu.Lock()
-	l.runningWorkflows[workflow.Name] = resp.ID
+	delete(l.runningWorkflows, workflowName)
 	l.mu.Unlock()
 
 	return nil
 }
+
+func (l *LocalServiceProvider) stopContainer(containerID string) error {
+	stopTimeout := 10 * time.Second
+	timeout := int(stopTimeout.Seconds())
+
+	return l.dockerClient.ContainerStop(l.ctx, containerID, container.StopOptions{Timeout: &timeout})
+}
+
+func (l *LocalServiceProvider) removeContainer(containerID string) error {
+	stopErr := l.stopContainer(containerID)
+	if stopErr != nil {
+		l.logger.Error("failed to stop docker container gracefully", "container-id", containerID, "err", stopErr)
+	}
+
+	return l.dockerClient.ContainerRemove(l.ctx, containerID, container.RemoveOptions{RemoveVolumes: true, Force: true})
+}
+
+func (l *LocalServiceProvider) rollbackContainer(containerID string, reason string) {
+	stopTimeout := 10 * time.Second
+	timeout := int(stopTimeout.Seconds())
+
+	stopErr := l.dockerClient.ContainerStop(l.ctx, containerID, container.StopOptions{Timeout: &timeout})
+	if stopErr != nil {
+		l.logger.Error("failed to stop container during rollback", "reason", reason, "container-id", containerID, "err", stopErr)
+	}
+
+	removeErr := l.dockerClient.ContainerRemove(l.ctx, containerID, container.RemoveOptions{Force: true})
+	if removeErr != nil {
+		l.logger.Error("failed to remove container during rollback", "reason", reason, "container-id", containerID, "err", removeErr)
+	}
+}
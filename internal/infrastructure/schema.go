@@ -0,0 +1,420 @@
+package infrastructure
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ferretcode/scavenger/pkg/types"
+)
+
+const (
+	FormatDateTime = "date-time"
+	FormatDate     = "date"
+	FormatEmail    = "email"
+	FormatURI      = "uri"
+	// currency is not a json schema format, it tells the worker to extract
+	// an amount and lets validation accept amounts like "$1,299.99"
+	FormatCurrency = "currency"
+)
+
+var SchemaFormats = []string{FormatDateTime, FormatDate, FormatEmail, FormatURI, FormatCurrency}
+
+// SchemaFieldKey converts a field name to the property key used in the schema
+func SchemaFieldKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "-")
+}
+
+// Validate checks that the schema is well formed, that nested fields only
+// belong to objects, and that enums and formats fit the type of their field
+func (s Schema) Validate() error {
+	return validateProperties("", s.Properties, s.Required)
+}
+
+func validateProperties(path string, properties map[string]Field, required []string) error {
+	for _, key := range required {
+		if _, ok := properties[key]; !ok {
+			location := path
+			if location == "" {
+				location = "schema"
+			}
+			return &ValidationError{Message: fmt.Sprintf("%s: required field %q is not defined", location, key)}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(properties)) {
+		if err := validateField(joinFieldPath(path, key), properties[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateField(path string, field Field) error {
+	fieldType, ok := field.Type.Resolve()
+	if !ok {
+		_, err := ParseFieldType(string(field.Type))
+		return fieldError(path, err)
+	}
+
+	if len(field.Properties) > 0 && fieldType != FieldTypeObject {
+		return &ValidationError{Message: fmt.Sprintf("%s: only object fields can have nested fields", path)}
+	}
+
+	if field.Items != nil && fieldType != FieldTypeArray {
+		return &ValidationError{Message: fmt.Sprintf("%s: only array fields can have an item type", path)}
+	}
+
+	if field.Format != "" {
+		if !slices.Contains(SchemaFormats, field.Format) {
+			return &ValidationError{Message: fmt.Sprintf("%s: unknown format %q, expected one of %s", path, field.Format, strings.Join(SchemaFormats, ", "))}
+		}
+
+		if fieldType != FieldTypeString && !(field.Format == FormatCurrency && fieldType == FieldTypeNumber) {
+			return &ValidationError{Message: fmt.Sprintf("%s: format %q cannot be used on a %s field", path, field.Format, field.Type)}
+		}
+	}
+
+	if len(field.Enum) > 0 {
+		if fieldType == FieldTypeObject || fieldType == FieldTypeArray {
+			return &ValidationError{Message: fmt.Sprintf("%s: enums can only be used on string, number and boolean fields", path)}
+		}
+
+		for _, value := range field.Enum {
+			switch value.(type) {
+			case string, float64, bool, int, int32, int64:
+			default:
+				return &ValidationError{Message: fmt.Sprintf("%s: enum values must be strings, numbers or booleans", path)}
+			}
+
+			if matches, checked := field.Type.Matches(value); checked && !matches {
+				return &ValidationError{Message: fmt.Sprintf("%s: enum value %v is not a %s", path, value, field.Type)}
+			}
+		}
+	}
+
+	if field.Items != nil {
+		if err := validateField(path+"[]", *field.Items); err != nil {
+			return err
+		}
+	}
+
+	return validateProperties(path, field.Properties, field.Required)
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// schemaNode is a field submitted by the workflow form. the form is flat,
+// nested fields name the path of their parent field
+type schemaNode struct {
+	key      string
+	field    Field
+	optional bool
+	children []*schemaNode
+}
+
+// schemaFromForm builds the schema from the field inputs of the workflow
+// form. a field is nested inside an object, or inside the elements of an
+// array of objects, by setting its parent to the path of that field
+func schemaFromForm(form url.Values, fieldCounter int) (Schema, error) {
+	type formField struct {
+		path   string
+		parent string
+		node   *schemaNode
+	}
+
+	formFields := []formField{}
+
+	for i := 0; i <= fieldCounter; i++ {
+		fieldName := form.Get(fmt.Sprintf("fieldName_%d", i))
+		fieldType := form.Get(fmt.Sprintf("fieldType_%d", i))
+		fieldDesc := form.Get(fmt.Sprintf("fieldDesc_%d", i))
+
+		if fieldName == "" || fieldType == "" || fieldDesc == "" {
+			// the field was deleted
+			// the field counter is never udpated when a field is deleted
+			continue
+		}
+
+		parsedType, err := ParseFieldType(fieldType)
+		if err != nil {
+			return Schema{}, fieldError(fieldName, err)
+		}
+
+		field := Field{
+			Name: fieldName,
+			Type: parsedType,
+			Desc: fieldDesc,
+		}
+
+		// enums and formats of an array of strings or numbers apply to
+		// its elements
+		target := &field
+
+		if itemType := strings.TrimSpace(form.Get(fmt.Sprintf("fieldItems_%d", i))); itemType != "" {
+			parsedItemType, err := ParseFieldType(itemType)
+			if err != nil {
+				return Schema{}, fieldError(fieldName, err)
+			}
+
+			field.Items = &Field{Type: parsedItemType}
+
+			if parsedItemType != FieldTypeObject {
+				target = field.Items
+			}
+		}
+
+		target.Format = strings.TrimSpace(form.Get(fmt.Sprintf("fieldFormat_%d", i)))
+
+		enum, err := parseEnumValues(target.Type, form.Get(fmt.Sprintf("fieldEnum_%d", i)))
+		if err != nil {
+			return Schema{}, err
+		}
+		target.Enum = enum
+
+		key := SchemaFieldKey(fieldName)
+		parent := strings.TrimSpace(form.Get(fmt.Sprintf("fieldParent_%d", i)))
+
+		formFields = append(formFields, formField{
+			path:   joinFieldPath(parent, key),
+			parent: parent,
+			node: &schemaNode{
+				key:      key,
+				field:    field,
+				optional: form.Get(fmt.Sprintf("fieldOptional_%d", i)) == "true",
+			},
+		})
+	}
+
+	// parents are attached before their children regardless of the order
+	// the fields were added in
+	slices.SortStableFunc(formFields, func(a, b formField) int {
+		return strings.Count(a.path, ".") - strings.Count(b.path, ".")
+	})
+
+	nodes := make(map[string]*schemaNode)
+	roots := []*schemaNode{}
+
+	for _, f := range formFields {
+		if _, ok := nodes[f.path]; ok {
+			return Schema{}, &ValidationError{Message: fmt.Sprintf("field %q is defined more than once", f.path)}
+		}
+
+		if f.parent == "" {
+			roots = append(roots, f.node)
+		} else {
+			parent, ok := nodes[f.parent]
+			if !ok {
+				return Schema{}, &ValidationError{Message: fmt.Sprintf("field %q is nested under %q, which is not defined", f.path, f.parent)}
+			}
+
+			if !acceptsNestedFields(parent.field) {
+				return Schema{}, &ValidationError{Message: fmt.Sprintf("field %q is nested under %q, which is not an object or a list of objects", f.path, f.parent)}
+			}
+
+			parent.children = append(parent.children, f.node)
+		}
+
+		nodes[f.path] = f.node
+	}
+
+	properties, required := buildProperties(roots)
+
+	schema := Schema{
+		Type:       "object",
+		Title:      "Generated Schema",
+		Properties: properties,
+		Required:   required,
+	}
+
+	if err := schema.Validate(); err != nil {
+		return Schema{}, err
+	}
+
+	return schema, nil
+}
+
+func acceptsNestedFields(field Field) bool {
+	if field.Items != nil {
+		return field.Items.Type.Is(FieldTypeObject)
+	}
+	return field.Type.Is(FieldTypeObject)
+}
+
+func buildProperties(nodes []*schemaNode) (map[string]Field, []string) {
+	properties := make(map[string]Field)
+	required := []string{}
+
+	for _, node := range nodes {
+		field := node.field
+
+		if len(node.children) > 0 {
+			nested, nestedRequired := buildProperties(node.children)
+
+			if field.Items != nil {
+				items := *field.Items
+				items.Properties, items.Required = nested, nestedRequired
+				field.Items = &items
+			} else {
+				field.Properties, field.Required = nested, nestedRequired
+			}
+		}
+
+		properties[node.key] = field
+		if !node.optional {
+			required = append(required, node.key)
+		}
+	}
+
+	return properties, required
+}
+
+// parseEnumValues splits a comma separated list of enum values and converts
+// them to the type of the field
+func parseEnumValues(fieldType FieldType, input string) ([]any, error) {
+	values := []any{}
+
+	for _, value := range strings.Split(input, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		switch fieldType {
+		case FieldTypeNumber, FieldTypeInteger:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("enum value %q is not a number", value)}
+			}
+			values = append(values, n)
+		case FieldTypeBoolean:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, &ValidationError{Message: fmt.Sprintf("enum value %q is not a boolean", value)}
+			}
+			values = append(values, b)
+		default:
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	return values, nil
+}
+
+// schemaFromConfig converts the schema of a workflow spec. fields are
+// required unless they are marked optional
+func schemaFromConfig(fields map[string]types.WorkflowSchemaField) (Schema, error) {
+	properties, required, err := propertiesFromConfig("", fields)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	return Schema{
+		Type:       "object",
+		Title:      "Generated Schema",
+		Properties: properties,
+		Required:   required,
+	}, nil
+}
+
+func propertiesFromConfig(path string, fields map[string]types.WorkflowSchemaField) (map[string]Field, []string, error) {
+	properties := make(map[string]Field)
+	required := []string{}
+
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		field, err := fieldFromConfig(joinFieldPath(path, key), fields[key])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		properties[key] = field
+
+		if !fields[key].Optional {
+			required = append(required, key)
+		}
+	}
+
+	return properties, required, nil
+}
+
+func fieldFromConfig(path string, config types.WorkflowSchemaField) (Field, error) {
+	fieldType, err := ParseFieldType(config.Type)
+	if err != nil {
+		return Field{}, fieldError(path, err)
+	}
+
+	field := Field{
+		Name:   config.Name,
+		Type:   fieldType,
+		Desc:   config.Desc,
+		Format: config.Format,
+		Enum:   config.Enum,
+	}
+
+	if len(config.Properties) > 0 {
+		field.Properties, field.Required, err = propertiesFromConfig(path, config.Properties)
+		if err != nil {
+			return Field{}, err
+		}
+	}
+
+	if config.Items != nil {
+		items, err := fieldFromConfig(path+"[]", *config.Items)
+		if err != nil {
+			return Field{}, err
+		}
+		field.Items = &items
+	}
+
+	return field, nil
+}
+
+// fieldError prefixes the error of a field with its path
+func fieldError(path string, err error) error {
+	return &ValidationError{Message: fmt.Sprintf("%s: %s", path, err)}
+}
+
+func configFromProperties(properties map[string]Field, required []string) map[string]types.WorkflowSchemaField {
+	fields := make(map[string]types.WorkflowSchemaField)
+
+	for key, field := range properties {
+		fields[key] = configFromField(field, slices.Contains(required, key))
+	}
+
+	return fields
+}
+
+func configFromField(field Field, required bool) types.WorkflowSchemaField {
+	config := types.WorkflowSchemaField{
+		Name:     field.Name,
+		Type:     string(field.Type),
+		Desc:     field.Desc,
+		Optional: !required,
+		Format:   field.Format,
+		Enum:     field.Enum,
+	}
+
+	if len(field.Properties) > 0 {
+		config.Properties = configFromProperties(field.Properties, field.Required)
+	}
+
+	if field.Items != nil {
+		items := configFromField(*field.Items, true)
+		config.Items = &items
+	}
+
+	return config
+}
@@ -0,0 +1,218 @@
+package infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// only the first errors of a result are kept, a result that is wrong in
+// every row would otherwise produce one error per row
+const maxValidationErrors = 20
+
+// HasFields reports whether the schema describes any fields. results of
+// workflows without fields are not validated
+func (s Schema) HasFields() bool {
+	return len(s.Properties) > 0
+}
+
+// ValidateResult checks a worker result against the schema and returns
+// what is wrong with it, or nil if it is valid. workers return either a
+// single object or a list of objects, one per extracted block
+func (s Schema) ValidateResult(raw []byte) []string {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return []string{"result is not valid json: " + err.Error()}
+	}
+
+	errs := []string{}
+
+	switch v := value.(type) {
+	case []any:
+		if len(v) == 0 {
+			return []string{"result is an empty list"}
+		}
+
+		for i, element := range v {
+			s.validateObject(fmt.Sprintf("[%d]", i), element, &errs)
+		}
+	default:
+		s.validateObject("", v, &errs)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+func (s Schema) validateObject(path string, value any, errs *[]string) {
+	validateObject(path, s.Properties, s.Required, value, errs)
+}
+
+func validateObject(path string, properties map[string]Field, required []string, value any, errs *[]string) {
+	object, ok := value.(map[string]any)
+	if !ok {
+		addValidationError(errs, "%s: expected an object", pathOrRoot(path))
+		return
+	}
+
+	for _, key := range required {
+		if fieldValue, ok := object[key]; !ok || fieldValue == nil {
+			addValidationError(errs, "%s: missing required field %q", pathOrRoot(path), key)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(properties)) {
+		fieldValue, ok := object[key]
+		if !ok || fieldValue == nil {
+			continue
+		}
+
+		validateValue(pathOrRoot(path)+"."+key, properties[key], fieldValue, errs)
+	}
+}
+
+// validateValue checks a value against its field and the nested fields
+// of objects and arrays
+func validateValue(path string, field Field, value any, errs *[]string) {
+	if matches, checked := field.Type.Matches(value); checked && !matches {
+		expected, _ := field.Type.Resolve()
+		addValidationError(errs, "%s: expected %s, got %s", path, expected, jsonTypeOf(value))
+		return
+	}
+
+	if len(field.Enum) > 0 && !slices.ContainsFunc(field.Enum, func(e any) bool { return enumValueEquals(e, value) }) {
+		enum, _ := json.Marshal(field.Enum)
+		addValidationError(errs, "%s: %v is not one of %s", path, value, enum)
+	}
+
+	if field.Format != "" && !valueMatchesFormat(field.Format, value) {
+		addValidationError(errs, "%s: %v is not a valid %s", path, value, field.Format)
+	}
+
+	if len(field.Properties) > 0 {
+		validateObject(path, field.Properties, field.Required, value, errs)
+	}
+
+	if elements, ok := value.([]any); ok && field.Items != nil {
+		for i, element := range elements {
+			if element == nil {
+				continue
+			}
+
+			validateValue(fmt.Sprintf("%s[%d]", path, i), *field.Items, element, errs)
+		}
+	}
+}
+
+// enumValueEquals compares an enum value with a decoded json value. enums
+// read back from the database can hold integers where json has float64
+func enumValueEquals(enumValue any, value any) bool {
+	switch e := enumValue.(type) {
+	case int32:
+		enumValue = float64(e)
+	case int64:
+		enumValue = float64(e)
+	case int:
+		enumValue = float64(e)
+	}
+
+	return enumValue == value
+}
+
+var currencyPattern = regexp.MustCompile(`^-?(?:[A-Z]{3} ?|\p{Sc} ?)?-?\d(?:[\d,.' ]*\d)?(?: ?[A-Z]{3}| ?\p{Sc})?$`)
+
+func valueMatchesFormat(format string, value any) bool {
+	if format == FormatCurrency {
+		if _, ok := value.(float64); ok {
+			return true
+		}
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	switch format {
+	case FormatDateTime:
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case FormatDate:
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case FormatEmail:
+		address, err := mail.ParseAddress(s)
+		return err == nil && address.Address == s
+	case FormatURI:
+		parsed, err := url.Parse(s)
+		return err == nil && parsed.Scheme != ""
+	case FormatCurrency:
+		return currencyPattern.MatchString(strings.TrimSpace(s))
+	default:
+		return true
+	}
+}
+
+func jsonTypeOf(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "null"
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "result"
+	}
+	return path
+}
+
+func addValidationError(errs *[]string, format string, args ...any) {
+	if len(*errs) < maxValidationErrors {
+		*errs = append(*errs, fmt.Sprintf(format, args...))
+	}
+}
+
+// RecordValidationFailure counts a result that failed validation against
+// the workflow and keeps its first error for the dashboard
+func RecordValidationFailure(ctx context.Context, db *mongo.Client, databaseName string, workflowName string, validationErrors []string) error {
+	lastError := ""
+	if len(validationErrors) > 0 {
+		lastError = validationErrors[0]
+	}
+
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "validationfailures", Value: 1}}},
+		{Key: "$set", Value: bson.D{
+			{Key: "lastvalidationerror", Value: lastError},
+			{Key: "lastvalidationfailureat", Value: time.Now().UTC()},
+		}},
+	}
+
+	_, err := db.Database(databaseName).Collection("workflows").UpdateOne(ctx, bson.D{{Key: "name", Value: workflowName}}, update)
+
+	return err
+}
@@ -0,0 +1,142 @@
+package infrastructure_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+)
+
+func TestValidateResult(t *testing.T) {
+	schema := infrastructure.Schema{
+		Type:  "object",
+		Title: "Products",
+		Properties: map[string]infrastructure.Field{
+			"name":   {Name: "name", Type: infrastructure.FieldTypeString},
+			"price":  {Name: "price", Type: infrastructure.FieldTypeString, Format: infrastructure.FormatCurrency},
+			"stock":  {Name: "stock", Type: infrastructure.FieldTypeInteger},
+			"status": {Name: "status", Type: infrastructure.FieldTypeString, Enum: []any{"new", "used"}},
+			"url":    {Name: "url", Type: infrastructure.FieldTypeString, Format: infrastructure.FormatURI},
+			"seller": {
+				Name: "seller",
+				Type: infrastructure.FieldTypeObject,
+				Properties: map[string]infrastructure.Field{
+					"email": {Name: "email", Type: infrastructure.FieldTypeString, Format: infrastructure.FormatEmail},
+				},
+				Required: []string{"email"},
+			},
+			"tags": {Name: "tags", Type: infrastructure.FieldTypeArray, Items: &infrastructure.Field{Type: infrastructure.FieldTypeString}},
+			// saved before field types were checked
+			"size": {Name: "size", Type: "int", Enum: []any{int32(1), int64(2)}},
+		},
+		Required: []string{"name"},
+	}
+
+	tests := []struct {
+		name   string
+		result string
+		want   []string
+	}{
+		{
+			name:   "valid object",
+			result: `{"name": "lamp", "price": "$12.50", "stock": 3, "status": "new", "url": "https://example.com", "seller": {"email": "a@example.com"}, "tags": ["home", null]}`,
+		},
+		{
+			name:   "valid list",
+			result: `[{"name": "lamp", "price": "12.50 EUR"}, {"name": "desk", "size": 2}]`,
+		},
+		{
+			name:   "null optional fields are skipped",
+			result: `{"name": "lamp", "price": null, "seller": null}`,
+		},
+		{
+			name:   "not json",
+			result: `lamp`,
+			want:   []string{"result is not valid json: invalid character 'l' looking for beginning of value"},
+		},
+		{
+			name:   "empty list",
+			result: `[]`,
+			want:   []string{"result is an empty list"},
+		},
+		{
+			name:   "not an object",
+			result: `"lamp"`,
+			want:   []string{"result: expected an object"},
+		},
+		{
+			name:   "missing and null required fields",
+			result: `[{"stock": 1}, {"name": null}]`,
+			want: []string{
+				`[0]: missing required field "name"`,
+				`[1]: missing required field "name"`,
+			},
+		},
+		{
+			name:   "wrong types",
+			result: `{"name": 1, "stock": 1.5, "tags": "home"}`,
+			want: []string{
+				"result.name: expected string, got number",
+				"result.stock: expected integer, got number",
+				"result.tags: expected array, got string",
+			},
+		},
+		{
+			name:   "enums and formats",
+			result: `{"name": "lamp", "status": "broken", "url": "example.com", "price": "cheap", "size": 3}`,
+			want: []string{
+				"result.price: cheap is not a valid currency",
+				`result.size: 3 is not one of [1,2]`,
+				`result.status: broken is not one of ["new","used"]`,
+				"result.url: example.com is not a valid uri",
+			},
+		},
+		{
+			name:   "nested fields",
+			result: `{"name": "lamp", "seller": {"email": "not an email"}, "tags": ["home", 2]}`,
+			want: []string{
+				"result.seller.email: not an email is not a valid email",
+				"result.tags[1]: expected string, got number",
+			},
+		},
+		{
+			name:   "missing nested required field",
+			result: `{"name": "lamp", "seller": {}}`,
+			want:   []string{`result.seller: missing required field "email"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := schema.ValidateResult([]byte(test.result))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ValidateResult(%s) = %q, want %q", test.result, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateResultCapsErrors(t *testing.T) {
+	schema := infrastructure.Schema{
+		Properties: map[string]infrastructure.Field{"name": {Name: "name", Type: infrastructure.FieldTypeString}},
+		Required:   []string{"name"},
+	}
+
+	rows := make([]string, 50)
+	for i := range rows {
+		rows[i] = "{}"
+	}
+
+	got := schema.ValidateResult([]byte("[" + strings.Join(rows, ",") + "]"))
+	if len(got) != infrastructure.MaxValidationErrors {
+		t.Fatalf("len(ValidateResult()) = %d, want %d", len(got), infrastructure.MaxValidationErrors)
+	}
+
+	// the first errors are kept
+	last := infrastructure.MaxValidationErrors - 1
+	if want := fmt.Sprintf("[%d]: missing required field %q", last, "name"); got[last] != want {
+		t.Errorf("last error = %q, want %q", got[last], want)
+	}
+}
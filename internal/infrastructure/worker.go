@@ -0,0 +1,131 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ferretcode/scavenger/pkg/types"
+)
+
+var ErrWorkflowPaused = errors.New("this workflow is paused")
+
+const defaultWorkerImage = "sthanguy/scavenger-scraper"
+
+// RunTokenHeader carries the run token of a workflow to its worker
+const RunTokenHeader = "X-Scavenger-Run-Token"
+
+// WorkerRunsHeader is set on the health check of workers that accept runs
+// from the control plane. the published worker image does not set it and
+// schedules its own runs from CRONTAB
+const WorkerRunsHeader = "X-Scavenger-Worker-Runs"
+
+// workerImage returns the worker image set with WORKER_IMAGE, or the
+// published one
+func workerImage(config *types.ScavengerConfig) string {
+	if config.WorkerImage != "" {
+		return config.WorkerImage
+	}
+	return defaultWorkerImage
+}
+
+var workerClient = &http.Client{Timeout: 10 * time.Second}
+
+// waiting for a run has no client timeout, the caller's context bounds it
+var workerWaitClient = &http.Client{}
+
+// TriggerWorkflowRun asks the workflow worker to enqueue a scrape right away.
+// the result is broadcast by the worker like any other run
+func TriggerWorkflowRun(ctx context.Context, workflow Workflow) error {
+	return triggerWorkflowRun(ctx, workflow, false)
+}
+
+// RunWorkflowAndWait enqueues a scrape and blocks until the worker
+// has finished it
+func RunWorkflowAndWait(ctx context.Context, workflow Workflow) error {
+	return triggerWorkflowRun(ctx, workflow, true)
+}
+
+func triggerWorkflowRun(ctx context.Context, workflow Workflow, wait bool) error {
+	if workflow.IsPaused() {
+		return ErrWorkflowPaused
+	}
+
+	runUri := strings.TrimSuffix(workflow.ServiceUri, "/") + "/run"
+	client := workerClient
+
+	if wait {
+		runUri += "?wait=true"
+		client = workerWaitClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, runUri, nil)
+	if err != nil {
+		return err
+	}
+
+	// workers deployed before run tokens were added accept any request
+	if workflow.RunToken != "" {
+		req.Header.Set(RunTokenHeader, workflow.RunToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("worker for workflow %s rejected run request with status %d: %s", workflow.Name, resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// WorkerAcceptsRuns asks the worker of the workflow whether it takes runs
+// from the control plane
+func WorkerAcceptsRuns(ctx context.Context, workflow Workflow) (bool, error) {
+	healthUri := strings.TrimSuffix(workflow.ServiceUri, "/") + "/healthz"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthUri, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := workerClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("worker for workflow %s failed its health check with status %d", workflow.Name, resp.StatusCode)
+	}
+
+	return resp.Header.Get(WorkerRunsHeader) == "true", nil
+}
+
+// ensureRunToken gives the workflow a run token unless it already has one,
+// so redeploying a worker keeps the token the control plane sends
+func ensureRunToken(workflow *Workflow) error {
+	if workflow.RunToken != "" {
+		return nil
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	workflow.RunToken = hex.EncodeToString(b)
+
+	return nil
+}
@@ -0,0 +1,13 @@
+package jsonpointer
+
+import "strings"
+
+// Escape escapes a key for use as a JSON pointer token
+func Escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// Unescape reverses Escape
+func Unescape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
@@ -0,0 +1,371 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"github.com/robfig/cron/v3"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	tickInterval = time.Second
+	// upper bound for a single scrape, including time spent waiting for a run slot
+	runTimeout = 10 * time.Minute
+	// how long to trust what a worker said about accepting runs, a
+	// redeployed worker keeps its service uri but can run another image
+	workerCheckInterval = 10 * time.Minute
+	// workers that did not answer are asked again sooner, a new worker is
+	// not scheduled until it answers
+	workerRetryInterval = time.Minute
+	workerCheckTimeout  = 10 * time.Second
+)
+
+// Scheduler fires workflow runs from the control plane according to each
+// workflow's cron expression, and limits how many run at the same time.
+// workers that do not accept runs keep scheduling themselves
+type Scheduler struct {
+	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+
+	slots chan struct{}
+
+	mu      sync.Mutex
+	entries map[string]*entry // map[workflowName]entry
+
+	// only one sync runs at a time, workers is only used by it
+	syncing atomic.Bool
+	workers map[string]workerCheck // map[workflowName]workerCheck
+}
+
+// workerCheck is the last answer of a workflow worker about accepting runs
+// from the control plane
+type workerCheck struct {
+	serviceUri  string
+	acceptsRuns bool
+	nextCheckAt time.Time
+}
+
+type entry struct {
+	workflow infrastructure.Workflow
+	schedule cron.Schedule
+	next     time.Time
+	running  bool
+}
+
+// runTimes is a pending update of a workflow's next run, written once the
+// scheduler lock is released
+type runTimes struct {
+	workflowName string
+	nextRunAt    time.Time
+}
+
+func NewScheduler(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+) *Scheduler {
+	maxConcurrentRuns := config.MaxConcurrentRuns
+	if maxConcurrentRuns <= 0 {
+		maxConcurrentRuns = 1
+	}
+
+	return &Scheduler{
+		Config:  config,
+		db:      db,
+		logger:  logger,
+		ctx:     ctx,
+		slots:   make(chan struct{}, maxConcurrentRuns),
+		entries: make(map[string]*entry),
+		workers: make(map[string]workerCheck),
+	}
+}
+
+// Run fires due workflows until the scheduler context is cancelled,
+// reloading workflows from the database every syncInterval
+func (s *Scheduler) Run(syncInterval time.Duration) {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	var lastSync time.Time
+
+	for {
+		now := time.Now()
+
+		// syncing asks every worker whether it accepts runs, it runs on
+		// its own so slow workers do not hold up due runs
+		if now.Sub(lastSync) >= syncInterval && s.syncing.CompareAndSwap(false, true) {
+			go func() {
+				defer s.syncing.Store(false)
+
+				if err := s.sync(now); err != nil {
+					s.logger.Error("error syncing scheduler with workflows", "err", err)
+				}
+			}()
+			lastSync = now
+		}
+
+		s.tick(now)
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) sync(now time.Time) error {
+	workflows, err := infrastructure.ListWorkflows(s.ctx, s.db, s.Config.DatabaseName)
+	if err != nil {
+		return err
+	}
+
+	// workers are asked before taking the lock, they can be slow to answer
+	acceptsRuns := s.checkWorkers(workflows, now)
+
+	updates := s.syncEntries(workflows, acceptsRuns, now)
+	s.saveAllRunTimes(updates)
+
+	return nil
+}
+
+// checkWorkers returns the workflows whose workers accept runs from the
+// control plane. workers are asked at the same time. a worker that cannot
+// be reached keeps its last answer until it is asked again
+func (s *Scheduler) checkWorkers(workflows []infrastructure.Workflow, now time.Time) map[string]bool {
+	type answer struct {
+		workflow infrastructure.Workflow
+		accepts  bool
+		err      error
+	}
+
+	current := make(map[string]bool)
+	answers := make(chan answer)
+	asked := 0
+
+	for _, workflow := range workflows {
+		// failed workflows have no worker to run
+		if workflow.IsPaused() || workflow.IsFailed() || workflow.ServiceUri == "" {
+			continue
+		}
+
+		current[workflow.Name] = true
+
+		check, ok := s.workers[workflow.Name]
+		if ok && check.serviceUri == workflow.ServiceUri && now.Before(check.nextCheckAt) {
+			continue
+		}
+
+		asked++
+
+		go func(workflow infrastructure.Workflow) {
+			ctx, cancel := context.WithTimeout(s.ctx, workerCheckTimeout)
+			defer cancel()
+
+			accepts, err := infrastructure.WorkerAcceptsRuns(ctx, workflow)
+			answers <- answer{workflow: workflow, accepts: accepts, err: err}
+		}(workflow)
+	}
+
+	for i := 0; i < asked; i++ {
+		answer := <-answers
+		workflowName := answer.workflow.Name
+		previous, ok := s.workers[workflowName]
+		known := ok && previous.serviceUri == answer.workflow.ServiceUri
+
+		if answer.err != nil {
+			s.logger.Warn("failed to ask worker whether it accepts runs", "workflow-name", workflowName, "err", answer.err)
+
+			s.workers[workflowName] = workerCheck{
+				serviceUri:  answer.workflow.ServiceUri,
+				acceptsRuns: known && previous.acceptsRuns,
+				nextCheckAt: now.Add(workerRetryInterval),
+			}
+			continue
+		}
+
+		if !answer.accepts && (!known || previous.acceptsRuns) {
+			s.logger.Info("worker schedules its own runs, not scheduling it from the control plane", "workflow-name", workflowName)
+		}
+
+		s.workers[workflowName] = workerCheck{
+			serviceUri:  answer.workflow.ServiceUri,
+			acceptsRuns: answer.accepts,
+			nextCheckAt: now.Add(workerCheckInterval),
+		}
+	}
+
+	acceptsRuns := make(map[string]bool)
+
+	for workflowName, check := range s.workers {
+		if !current[workflowName] {
+			delete(s.workers, workflowName)
+			continue
+		}
+
+		if check.acceptsRuns {
+			acceptsRuns[workflowName] = true
+		}
+	}
+
+	return acceptsRuns
+}
+
+func (s *Scheduler) syncEntries(workflows []infrastructure.Workflow, acceptsRuns map[string]bool, now time.Time) []runTimes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updates := []runTimes{}
+	desired := make(map[string]bool)
+
+	for _, workflow := range workflows {
+		if !acceptsRuns[workflow.Name] {
+			continue
+		}
+
+		desired[workflow.Name] = true
+
+		existing, ok := s.entries[workflow.Name]
+		if ok && existing.workflow.Cron == workflow.Cron {
+			// keep the schedule but pick up a redeployed service uri
+			existing.workflow = workflow
+			continue
+		}
+
+		schedule, err := infrastructure.ParseCron(workflow.Cron)
+		if err != nil {
+			s.logger.Error("invalid cron expression, workflow will not be scheduled", "workflow-name", workflow.Name, "cron", workflow.Cron, "err", err)
+			delete(s.entries, workflow.Name)
+			continue
+		}
+
+		next := schedule.Next(now)
+		if next.IsZero() {
+			s.logger.Error("cron expression never fires, workflow will not be scheduled", "workflow-name", workflow.Name, "cron", workflow.Cron)
+			delete(s.entries, workflow.Name)
+			continue
+		}
+
+		s.entries[workflow.Name] = &entry{
+			workflow: workflow,
+			schedule: schedule,
+			next:     next,
+			running:  ok && existing.running,
+		}
+
+		s.logger.Info("scheduled workflow", "workflow-name", workflow.Name, "cron", workflow.Cron, "next-run", next)
+		updates = append(updates, runTimes{workflowName: workflow.Name, nextRunAt: next})
+	}
+
+	for workflowName := range s.entries {
+		if !desired[workflowName] {
+			delete(s.entries, workflowName)
+			s.logger.Info("unscheduled workflow", "workflow-name", workflowName)
+		}
+	}
+
+	return updates
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	updates := s.due(now)
+	s.saveAllRunTimes(updates)
+}
+
+// due fires the workflows whose next run has passed and returns the next
+// runs to save for the ones that were skipped
+func (s *Scheduler) due(now time.Time) []runTimes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updates := []runTimes{}
+
+	for workflowName, e := range s.entries {
+		if now.Before(e.next) {
+			continue
+		}
+
+		e.next = e.schedule.Next(now)
+
+		if e.next.IsZero() {
+			// the schedule has no more runs, firing it now would repeat on every tick
+			s.logger.Error("cron expression never fires again, unscheduling workflow", "workflow-name", workflowName, "cron", e.workflow.Cron)
+			delete(s.entries, workflowName)
+			continue
+		}
+
+		if e.running {
+			s.logger.Warn("previous run is still in progress, skipping scheduled run", "workflow-name", workflowName, "next-run", e.next)
+			updates = append(updates, runTimes{workflowName: workflowName, nextRunAt: e.next})
+			continue
+		}
+
+		e.running = true
+
+		go s.fire(e.workflow, now, e.next)
+	}
+
+	return updates
+}
+
+func (s *Scheduler) fire(workflow infrastructure.Workflow, firedAt time.Time, next time.Time) {
+	defer func() {
+		s.mu.Lock()
+		if e, ok := s.entries[workflow.Name]; ok {
+			e.running = false
+		}
+		s.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(s.ctx, runTimeout)
+	defer cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		s.logger.Warn("timed out waiting for a run slot", "workflow-name", workflow.Name)
+		return
+	}
+	defer func() { <-s.slots }()
+
+	s.logger.Info("running scheduled workflow", "workflow-name", workflow.Name)
+	s.saveRunTimes(workflow.Name, &firedAt, next)
+
+	err := infrastructure.RunWorkflowAndWait(ctx, workflow)
+	if err != nil {
+		s.logger.Error("scheduled workflow run failed", "workflow-name", workflow.Name, "err", err)
+		return
+	}
+
+	s.logger.Info("scheduled workflow run finished", "workflow-name", workflow.Name, "duration", time.Since(firedAt))
+}
+
+func (s *Scheduler) saveAllRunTimes(updates []runTimes) {
+	for _, update := range updates {
+		s.saveRunTimes(update.workflowName, nil, update.nextRunAt)
+	}
+}
+
+func (s *Scheduler) saveRunTimes(workflowName string, lastRunAt *time.Time, nextRunAt time.Time) {
+	fields := bson.D{{Key: "nextrunat", Value: nextRunAt}}
+	if lastRunAt != nil {
+		fields = append(fields, bson.E{Key: "lastrunat", Value: *lastRunAt})
+	}
+
+	update := bson.D{{Key: "$set", Value: fields}}
+
+	_, err := s.db.Database(s.Config.DatabaseName).Collection("workflows").UpdateOne(s.ctx, bson.D{{Key: "name", Value: workflowName}}, update)
+	if err != nil {
+		s.logger.Error("error saving workflow run times", "workflow-name", workflowName, "err", err)
+	}
+}
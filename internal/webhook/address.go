@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+var (
+	errBlockedAddress = errors.New("webhooks cannot be delivered to loopback, private or link-local addresses")
+	errRedirect       = errors.New("webhook redirects are not followed")
+)
+
+// blockedIP reports whether an address belongs to the control plane's own
+// host or network, like the cloud metadata endpoint at 169.254.169.254
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified()
+}
+
+// blockedHost rejects hosts that are known to be internal without resolving
+// them. names that resolve to internal addresses are refused when dialing
+func blockedHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || host == "metadata.google.internal" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && blockedIP(ip)
+}
+
+// newDeliveryClient returns the client webhooks are posted with. the address
+// is checked after the host is resolved, so a public name pointing at an
+// internal address is refused too, and redirects are not followed
+func newDeliveryClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || blockedIP(ip) {
+				return errBlockedAddress
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// a proxy would be dialed instead of the webhook address
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   deliveryTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errRedirect
+		},
+	}
+}
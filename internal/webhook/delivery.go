@@ -0,0 +1,310 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	DeliveryStatusPending   = "pending"
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+)
+
+const (
+	deliveryTimeout     = 10 * time.Second
+	deliveryConcurrency = 4
+	maxDeliveryAttempts = 8
+
+	// a claimed delivery becomes due again after the lease, so deliveries
+	// in flight when the server stops are retried on the next start
+	deliveryLease = time.Minute
+
+	// backoff doubles after every failed attempt, 30s, 1m, 2m ... up to 1h
+	deliveryBaseBackoff = 30 * time.Second
+	deliveryMaxBackoff  = time.Hour
+
+	// only the start of the response body is kept in the delivery log
+	maxLoggedResponseBytes = 512
+)
+
+const (
+	SignatureHeader = "X-Scavenger-Signature"
+	TimestampHeader = "X-Scavenger-Timestamp"
+	DeliveryHeader  = "X-Scavenger-Delivery"
+)
+
+// Delivery is a single result queued for a webhook. the deliveries
+// collection is the retry queue, so queued results survive restarts
+type Delivery struct {
+	ID             bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	WebhookID      bson.ObjectID `bson:"webhook_id" json:"webhook_id"`
+	WorkflowName   string        `bson:"workflow_name" json:"workflow_name"`
+	ResultID       string        `bson:"result_id" json:"result_id"`
+	URL            string        `bson:"url" json:"url"`
+	Payload        string        `bson:"payload" json:"payload"`
+	Status         string        `bson:"status" json:"status"`
+	Attempts       int           `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time     `bson:"next_attempt_at" json:"next_attempt_at"`
+	LastAttemptAt  *time.Time    `bson:"last_attempt_at,omitempty" json:"last_attempt_at,omitempty"`
+	LastStatusCode int           `bson:"last_status_code,omitempty" json:"last_status_code,omitempty"`
+	LastError      string        `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CreatedAt      time.Time     `bson:"created_at" json:"created_at"`
+	DeliveredAt    *time.Time    `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// Enqueue queues a result for every webhook registered for the workflow
+func (s *WebhookService) Enqueue(workflowName string, resultID string, payload []byte) error {
+	webhooks, err := s.List(workflowName)
+	if err != nil {
+		return err
+	}
+
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	deliveries := make([]Delivery, 0, len(webhooks))
+
+	for _, webhook := range webhooks {
+		deliveries = append(deliveries, Delivery{
+			WebhookID:     webhook.ID,
+			WorkflowName:  workflowName,
+			ResultID:      resultID,
+			URL:           webhook.URL,
+			Payload:       string(payload),
+			Status:        DeliveryStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	_, err = s.deliveries().InsertMany(s.ctx, deliveries)
+
+	return err
+}
+
+// Run delivers due deliveries every interval until the service context is
+// cancelled
+func (s *WebhookService) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, deliveryConcurrency)
+
+	for {
+		for {
+			select {
+			case slots <- struct{}{}:
+			case <-s.ctx.Done():
+				return
+			}
+
+			delivery, err := s.claimDelivery()
+			if err != nil || delivery == nil {
+				<-slots
+
+				if err != nil {
+					s.logger.Error("error claiming webhook delivery", "err", err)
+				}
+				break
+			}
+
+			go func() {
+				defer func() { <-slots }()
+				s.attempt(*delivery)
+			}()
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimDelivery leases the next due delivery and counts the attempt
+func (s *WebhookService) claimDelivery() (*Delivery, error) {
+	now := time.Now().UTC()
+
+	filter := bson.D{
+		{Key: "status", Value: DeliveryStatusPending},
+		{Key: "next_attempt_at", Value: bson.D{{Key: "$lte", Value: now}}},
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "next_attempt_at", Value: now.Add(deliveryLease)}}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	delivery := Delivery{}
+
+	err := s.deliveries().FindOneAndUpdate(s.ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (s *WebhookService) attempt(delivery Delivery) {
+	webhook := Webhook{}
+
+	err := s.webhooks().FindOne(s.ctx, bson.D{{Key: "_id", Value: delivery.WebhookID}}).Decode(&webhook)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			s.finish(delivery, DeliveryStatusFailed, 0, errors.New("webhook was deleted"))
+			return
+		}
+
+		s.logger.Error("error loading webhook", "webhook-id", delivery.WebhookID.Hex(), "err", err)
+		return
+	}
+
+	statusCode, err := s.post(webhook, delivery)
+	if err == nil {
+		s.finish(delivery, DeliveryStatusDelivered, statusCode, nil)
+		return
+	}
+
+	if delivery.Attempts >= maxDeliveryAttempts {
+		s.logger.Warn("webhook delivery failed, giving up", "workflow-name", delivery.WorkflowName, "url", delivery.URL, "attempts", delivery.Attempts, "err", err)
+		s.finish(delivery, DeliveryStatusFailed, statusCode, err)
+		return
+	}
+
+	s.retryLater(delivery, statusCode, err)
+}
+
+// post sends the delivery payload, any non 2xx response is an error
+func (s *WebhookService) post(webhook Webhook, delivery Delivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "scavenger-webhooks")
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedResponseBytes))
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s: %s", resp.Status, body)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with the webhook secret. receivers should recompute it and compare it to
+// the signature header, and reject old timestamps to prevent replays
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookService) finish(delivery Delivery, status string, statusCode int, deliveryErr error) {
+	now := time.Now().UTC()
+
+	set := bson.D{
+		{Key: "status", Value: status},
+		{Key: "last_attempt_at", Value: now},
+		{Key: "last_status_code", Value: statusCode},
+	}
+
+	if deliveryErr != nil {
+		set = append(set, bson.E{Key: "last_error", Value: deliveryErr.Error()})
+	} else {
+		set = append(set, bson.E{Key: "last_error", Value: ""}, bson.E{Key: "delivered_at", Value: now})
+	}
+
+	s.updateDelivery(delivery, set)
+}
+
+func (s *WebhookService) retryLater(delivery Delivery, statusCode int, deliveryErr error) {
+	now := time.Now().UTC()
+
+	set := bson.D{
+		{Key: "next_attempt_at", Value: now.Add(backoff(delivery.Attempts))},
+		{Key: "last_attempt_at", Value: now},
+		{Key: "last_status_code", Value: statusCode},
+		{Key: "last_error", Value: deliveryErr.Error()},
+	}
+
+	s.updateDelivery(delivery, set)
+}
+
+func (s *WebhookService) updateDelivery(delivery Delivery, set bson.D) {
+	_, err := s.deliveries().UpdateByID(s.ctx, delivery.ID, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		s.logger.Error("error updating webhook delivery", "delivery-id", delivery.ID.Hex(), "err", err)
+	}
+}
+
+func backoff(attempts int) time.Duration {
+	delay := deliveryBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= deliveryMaxBackoff {
+			return deliveryMaxBackoff
+		}
+	}
+
+	return delay
+}
+
+func (s *WebhookService) recentDeliveries(workflowName string, limit int64) ([]Delivery, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(limit)
+
+	cur, err := s.deliveries().Find(s.ctx, bson.D{{Key: "workflow_name", Value: workflowName}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(s.ctx)
+
+	deliveries := []Delivery{}
+	if err := cur.All(s.ctx, &deliveries); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,345 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	webhooksCollection   = "webhooks"
+	deliveriesCollection = "webhook_deliveries"
+
+	// deliveries shown on the webhooks page of a workflow
+	deliveryLogLimit = 50
+
+	// deliveries are removed this long after they were queued
+	deliveryRetention = 30 * 24 * time.Hour
+)
+
+type WebhookService struct {
+	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+
+	client *http.Client
+}
+
+// Webhook is a url that every new result of a workflow is posted to
+type Webhook struct {
+	ID           bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	WorkflowName string        `bson:"workflow_name" json:"workflow_name"`
+	URL          string        `bson:"url" json:"url"`
+	// Secret signs each delivery, receivers use it to verify the signature
+	Secret    string    `bson:"secret" json:"-"`
+	CreatedBy string    `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+type webhooksPageData struct {
+	Workflow   infrastructure.Workflow
+	Webhooks   []Webhook
+	Deliveries []Delivery
+}
+
+func NewWebhookService(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+) WebhookService {
+	return WebhookService{
+		Config: config,
+		db:     db,
+		logger: logger,
+		ctx:    ctx,
+		client: newDeliveryClient(),
+	}
+}
+
+func (s *WebhookService) webhooks() *mongo.Collection {
+	return s.db.Database(s.Config.DatabaseName).Collection(webhooksCollection)
+}
+
+func (s *WebhookService) deliveries() *mongo.Collection {
+	return s.db.Database(s.Config.DatabaseName).Collection(deliveriesCollection)
+}
+
+func (s *WebhookService) EnsureIndexes() error {
+	_, err := s.webhooks().Indexes().CreateOne(s.ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "workflow_name", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.deliveries().Indexes().CreateMany(s.ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "workflow_name", Value: 1}, {Key: "created_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(deliveryRetention.Seconds())),
+		},
+	})
+
+	return err
+}
+
+func (s *WebhookService) RenderWebhooks(w http.ResponseWriter, r *http.Request, templates *template.Template) error {
+	workflow, err := infrastructure.FindWorkflow(s.ctx, s.db, s.Config.DatabaseName, chi.URLParam(r, "workflow_name"))
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return nil
+		}
+		return err
+	}
+
+	webhooks, err := s.List(workflow.Name)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := s.recentDeliveries(workflow.Name, deliveryLogLimit)
+	if err != nil {
+		return err
+	}
+
+	data := webhooksPageData{
+		Workflow:   *workflow,
+		Webhooks:   webhooks,
+		Deliveries: deliveries,
+	}
+
+	return templates.ExecuteTemplate(w, "webhooks.html", data)
+}
+
+func (s *WebhookService) CreateWebhook(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	workflowName := chi.URLParam(r, "workflow_name")
+
+	_, err := infrastructure.FindWorkflow(s.ctx, s.db, s.Config.DatabaseName, workflowName)
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return nil
+		}
+		return err
+	}
+
+	webhookURL := strings.TrimSpace(r.PostForm.Get("url"))
+	if err := validateWebhookURL(webhookURL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return err
+	}
+
+	webhook := Webhook{
+		WorkflowName: workflowName,
+		URL:          webhookURL,
+		Secret:       secret,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if session, ok := auth.SessionFromContext(r.Context()); ok {
+		webhook.CreatedBy = session.Username
+	}
+
+	_, err = s.webhooks().InsertOne(s.ctx, webhook)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("webhook registered", "workflow-name", workflowName, "url", webhookURL)
+
+	http.Redirect(w, r, "/workflows/"+workflowName+"/webhooks", http.StatusSeeOther)
+
+	return nil
+}
+
+func (s *WebhookService) DeleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	workflowName := chi.URLParam(r, "workflow_name")
+
+	id, err := bson.ObjectIDFromHex(r.PostForm.Get("webhookId"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return nil
+	}
+
+	_, err = s.webhooks().DeleteOne(s.ctx, bson.D{{Key: "_id", Value: id}, {Key: "workflow_name", Value: workflowName}})
+	if err != nil {
+		return err
+	}
+
+	// pending deliveries for a removed webhook would only ever fail
+	_, err = s.deliveries().UpdateMany(
+		s.ctx,
+		bson.D{{Key: "webhook_id", Value: id}, {Key: "status", Value: DeliveryStatusPending}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: DeliveryStatusFailed},
+			{Key: "last_error", Value: "webhook was deleted"},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/workflows/"+workflowName+"/webhooks", http.StatusSeeOther)
+
+	return nil
+}
+
+// DeleteWorkflowWebhooks removes the webhooks of a deleted workflow and
+// fails its pending deliveries, a new workflow with the same name must not
+// post to the old urls
+func (s *WebhookService) DeleteWorkflowWebhooks(workflowName string) error {
+	_, err := s.webhooks().DeleteMany(s.ctx, bson.D{{Key: "workflow_name", Value: workflowName}})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.deliveries().UpdateMany(
+		s.ctx,
+		bson.D{{Key: "workflow_name", Value: workflowName}, {Key: "status", Value: DeliveryStatusPending}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: DeliveryStatusFailed},
+			{Key: "last_error", Value: "workflow was deleted"},
+		}}},
+	)
+
+	return err
+}
+
+// RetryDelivery queues a failed delivery again with a fresh set of attempts
+func (s *WebhookService) RetryDelivery(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil
+	}
+
+	workflowName := chi.URLParam(r, "workflow_name")
+
+	id, err := bson.ObjectIDFromHex(r.PostForm.Get("deliveryId"))
+	if err != nil {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return nil
+	}
+
+	webhookExists, err := s.deliveryWebhookExists(id)
+	if err != nil {
+		return err
+	}
+
+	if !webhookExists {
+		http.Error(w, "the webhook for this delivery was deleted", http.StatusConflict)
+		return nil
+	}
+
+	_, err = s.deliveries().UpdateOne(
+		s.ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "workflow_name", Value: workflowName},
+			{Key: "status", Value: DeliveryStatusFailed},
+		},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: DeliveryStatusPending},
+			{Key: "attempts", Value: 0},
+			{Key: "next_attempt_at", Value: time.Now().UTC()},
+		}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/workflows/"+workflowName+"/webhooks", http.StatusSeeOther)
+
+	return nil
+}
+
+// List returns the webhooks registered for a workflow
+func (s *WebhookService) List(workflowName string) ([]Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cur, err := s.webhooks().Find(s.ctx, bson.D{{Key: "workflow_name", Value: workflowName}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(s.ctx)
+
+	webhooks := []Webhook{}
+	if err := cur.All(s.ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (s *WebhookService) deliveryWebhookExists(deliveryID bson.ObjectID) (bool, error) {
+	delivery := Delivery{}
+
+	err := s.deliveries().FindOne(s.ctx, bson.D{{Key: "_id", Value: deliveryID}}).Decode(&delivery)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+
+	count, err := s.webhooks().CountDocuments(s.ctx, bson.D{{Key: "_id", Value: delivery.WebhookID}})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func validateWebhookURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return &infrastructure.ValidationError{Message: "webhook url must be an absolute http or https url"}
+	}
+
+	if blockedHost(parsed.Hostname()) {
+		return &infrastructure.ValidationError{Message: errBlockedAddress.Error()}
+	}
+
+	return nil
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/changes"
+	"github.com/ferretcode/scavenger/internal/history"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+)
+
+// EnvelopeVersion is bumped whenever a field of Envelope changes meaning
+// or is removed. new fields can be added without bumping it
+const EnvelopeVersion = 1
+
+const (
+	formatRaw      = "raw"
+	formatEnvelope = "envelope"
+)
+
+// Envelope wraps a worker payload with where and when it came from
+type Envelope struct {
+	Version   int             `json:"version"`
+	Workflow  string          `json:"workflow"`
+	RunID     string          `json:"run_id,omitempty"`
+	ScrapedAt time.Time       `json:"scraped_at"`
+	SourceURL string          `json:"source_url"`
+	IsReplay  bool            `json:"is_replay"`
+	Data      json.RawMessage `json:"data"`
+	// Valid is only set for workflows with a schema to validate against
+	Valid            *bool    `json:"valid,omitempty"`
+	ValidationErrors []string `json:"validation_errors,omitempty"`
+	// Changed and Diff are only set for workflows with change detection
+	Changed *bool          `json:"changed,omitempty"`
+	Diff    []changes.Diff `json:"diff,omitempty"`
+}
+
+// NewEnvelope wraps a live message. the run id is the id of the history
+// result the message was recorded as
+func NewEnvelope(workflow infrastructure.Workflow, message Message) Envelope {
+	return Envelope{
+		Version:          EnvelopeVersion,
+		Workflow:         workflow.Name,
+		RunID:            message.ID,
+		ScrapedAt:        message.ScrapedAt,
+		SourceURL:        workflow.Request.Website,
+		IsReplay:         message.Replay,
+		Valid:            message.Valid,
+		ValidationErrors: message.ValidationErrors,
+		Changed:          message.Changed,
+		Diff:             message.Diff,
+		Data:             payloadJSON(message.Data),
+	}
+}
+
+// envelopeFromResult wraps a result replayed from history
+func envelopeFromResult(workflow infrastructure.Workflow, result history.Result) Envelope {
+	return Envelope{
+		Version:          EnvelopeVersion,
+		Workflow:         workflow.Name,
+		RunID:            result.ID.Hex(),
+		ScrapedAt:        result.ScrapedAt,
+		SourceURL:        workflow.Request.Website,
+		IsReplay:         true,
+		Data:             payloadJSON([]byte(result.Raw)),
+		Valid:            result.Valid,
+		ValidationErrors: result.ValidationErrors,
+		Changed:          result.Changed,
+	}
+}
+
+// messageFormat reads the format query param. clients get the raw worker
+// payload unless they opt in to envelopes, so existing consumers keep working
+func messageFormat(r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", formatRaw:
+		return formatRaw, true
+	case formatEnvelope:
+		return formatEnvelope, true
+	default:
+		return "", false
+	}
+}
+
+// payloadJSON embeds worker output in a json message as is when it is valid
+// json, otherwise as a string
+func payloadJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return data
+	}
+
+	encoded, _ := json.Marshal(string(data))
+	return encoded
+}
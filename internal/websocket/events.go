@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/history"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	eventKeepaliveInterval = 15 * time.Second
+	eventRetryMillis       = 5000
+)
+
+// HandleEventStream streams workflow results as server-sent events. each
+// event id is the id of the history result, clients that reconnect with
+// Last-Event-ID receive every result recorded since then first if their key
+// may read history, others continue with live results. new clients can ask
+// for stored results with since or last like on /connect. resumes and since
+// replays are sent in full, last is capped at 1000 results
+func (ws *WebsocketService) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	workflow, err := infrastructure.FindWorkflow(ws.ctx, ws.db, ws.Config.DatabaseName, chi.URLParam(r, "workflow_name"))
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		handleError(err, w, "events/find", ws.logger)
+		return
+	}
+
+	format, ok := messageFormat(r)
+	if !ok {
+		http.Error(w, "format must be raw or envelope", http.StatusBadRequest)
+		return
+	}
+
+	// the id of the last result sent, live messages up to it are skipped
+	var lastEventID bson.ObjectID
+	resuming := false
+
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		lastEventID, err = bson.ObjectIDFromHex(id)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		resuming = true
+	}
+
+	replay, err := parseReplayQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !replay.empty() && !allowsReplay(r) {
+		http.Error(w, "this api key is not allowed to "+auth.ScopeReadHistory, http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		handleError(fmt.Errorf("response writer does not support flushing"), w, "events/flush", ws.logger)
+		return
+	}
+
+	// subscribe before reading history so nothing recorded in between is missed
+	sub, err := ws.hubs.Subscribe(*workflow)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	// a reconnecting client picks up where it left off, ignoring since and last
+	paged := resuming || replay.since != nil
+
+	var missed []history.Result
+	if resuming {
+		// the id would otherwise let any subscriber read the whole history
+		if allowsReplay(r) {
+			missed, err = ws.history.After(workflow.Name, lastEventID, maxReplayResults)
+		}
+	} else {
+		missed, err = ws.replayResults(workflow.Name, replay)
+	}
+	if err != nil {
+		handleError(err, w, "events/history", ws.logger)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis)
+
+	lastReplayed, err := ws.sendReplay(workflow.Name, missed, paged, func(result history.Result) error {
+		data := []byte(result.Raw)
+		if format == formatEnvelope {
+			data, _ = json.Marshal(envelopeFromResult(*workflow, result))
+		}
+
+		writeEvent(w, result.ID.Hex(), data)
+
+		return r.Context().Err()
+	})
+	flusher.Flush()
+	if err != nil {
+		// the client can reconnect with the id of the last event it got
+		ws.logger.Error("replay to client failed", "err", err)
+		return
+	}
+
+	if !lastReplayed.IsZero() {
+		lastEventID = lastReplayed
+		resuming = true
+	}
+
+	ws.dashboardCardData.CliConnects.Add(1)
+	defer ws.dashboardCardData.CliConnects.Add(-1)
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ws.ctx.Done():
+			return
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+			flusher.Flush()
+		case message, ok := <-sub.Messages():
+			if !ok {
+				// the client fell behind, it can reconnect with Last-Event-ID
+				return
+			}
+
+			if resuming && !isNewerResult(message.ID, lastEventID) {
+				continue
+			}
+
+			data := message.Data
+			if format == formatEnvelope {
+				data, _ = json.Marshal(NewEnvelope(*workflow, message))
+			}
+
+			writeEvent(w, message.ID, data)
+			flusher.Flush()
+
+			if id, err := bson.ObjectIDFromHex(message.ID); err == nil {
+				lastEventID = id
+				resuming = true
+			}
+		}
+	}
+}
+
+func writeEvent(w io.Writer, id string, data []byte) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	io.WriteString(w, "event: result\n")
+
+	// event data cannot contain blank lines, compact json onto one line
+	// and send anything else line by line
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, data); err == nil {
+		data = compacted.Bytes()
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	io.WriteString(w, "\n")
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/history"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/x/mongo/driver/drivertest"
+)
+
+// mockDatabase returns a mongo client that answers every command with the
+// given replies in order, without a server
+func mockDatabase(t *testing.T, replies ...bson.D) *mongo.Client {
+	t.Helper()
+
+	clientOptions := options.Client()
+	clientOptions.Deployment = drivertest.NewMockDeployment(replies...)
+
+	db, err := mongo.Connect(clientOptions)
+	if err != nil {
+		t.Fatalf("failed to create mock database client: %v", err)
+	}
+
+	return db
+}
+
+// cursorReply is the reply to a find command returning documents
+func cursorReply(t *testing.T, documents ...any) bson.D {
+	t.Helper()
+
+	batch := bson.A{}
+	for _, document := range documents {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			t.Fatalf("failed to marshal document: %v", err)
+		}
+		batch = append(batch, bson.Raw(raw))
+	}
+
+	return bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "cursor", Value: bson.D{
+			{Key: "id", Value: int64(0)},
+			{Key: "ns", Value: "scavenger.workflows"},
+			{Key: "firstBatch", Value: batch},
+		}},
+	}
+}
+
+func TestEventStreamResumeScope(t *testing.T) {
+	workflow := infrastructure.Workflow{
+		Name: "prices",
+		// nothing listens here, the hub keeps retrying in the background
+		ServiceUri: "http://127.0.0.1:1",
+	}
+
+	stored := history.Result{
+		ID:           bson.NewObjectID(),
+		WorkflowName: "prices",
+		ScrapedAt:    time.Now().UTC(),
+		Raw:          `{"price": "stored"}`,
+	}
+
+	tests := []struct {
+		name        string
+		actions     []string
+		wantHistory bool
+	}{
+		{"subscribe only", []string{auth.ScopeSubscribe}, false},
+		{"read history", []string{auth.ScopeSubscribe, auth.ScopeReadHistory}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := mockDatabase(t, cursorReply(t, workflow), cursorReply(t, stored))
+
+			config := &types.ScavengerConfig{DatabaseName: "scavenger"}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			ctx := context.Background()
+
+			historyService := history.NewHistoryService(config, db, logger, ctx)
+			dashboardCardData := &types.DashboardCardData{}
+			hubs := NewHubManager(config, db, logger, ctx, &historyService, nil, dashboardCardData)
+			ws := NewWebsocketService(config, db, logger, ctx, hubs, &historyService, dashboardCardData)
+
+			routeContext := chi.NewRouteContext()
+			routeContext.URLParams.Add("workflow_name", "prices")
+
+			requestCtx, cancel := context.WithCancel(auth.ContextWithAPIKey(
+				context.WithValue(ctx, chi.RouteCtxKey, routeContext),
+				auth.ApiKey{Scopes: auth.ApiKeyScopes{Actions: test.actions}},
+			))
+
+			r := httptest.NewRequest(http.MethodGet, "/events/prices", nil).WithContext(requestCtx)
+			r.Header.Set("Last-Event-ID", bson.NilObjectID.Hex())
+			w := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				ws.HandleEventStream(w, r)
+				close(done)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			gotHistory := strings.Contains(w.Body.String(), "stored")
+			if gotHistory != test.wantHistory {
+				t.Errorf("stored result sent = %v, want %v, body %q", gotHistory, test.wantHistory, w.Body.String())
+			}
+		})
+	}
+}
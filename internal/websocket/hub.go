@@ -0,0 +1,443 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/changes"
+	"github.com/ferretcode/scavenger/internal/history"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/internal/webhook"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	hubRetryInterval = 5 * time.Second
+
+	// messages buffered per subscriber before it is considered too slow
+	// and dropped, so one stuck client cannot hold up the others
+	subscriberBufferSize = 16
+
+	// cachedResultHeader is set by workers on the websocket handshake when
+	// the first message is the result they already sent before. a restarted
+	// worker has no cached result, so its first message is a new scrape
+	cachedResultHeader = "X-Scavenger-Cached-Result"
+)
+
+// Message is a single result received from a workflow worker
+type Message struct {
+	// ID is the id of the history result the message was recorded as,
+	// it is empty if the message could not be recorded
+	ID           string
+	WorkflowName string
+	ScrapedAt    time.Time
+	Data         []byte
+	// Replay is set for the cached result the worker sends when the
+	// upstream connects and for the cached result sent to new subscribers
+	Replay bool
+	// Valid is only set for workflows with a schema to validate against
+	Valid            *bool
+	ValidationErrors []string
+	// Changed and Diff are only set when change detection is enabled
+	Changed *bool
+	Diff    []changes.Diff
+}
+
+// HubManager keeps one hub per workflow that has subscribers. each hub holds
+// a single upstream connection to the worker, records each message to
+// history and then fans it out
+type HubManager struct {
+	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+
+	history           *history.HistoryService
+	webhooks          *webhook.WebhookService
+	dashboardCardData *types.DashboardCardData
+
+	mu   sync.Mutex
+	hubs map[string]*hub // map[workflowName]hub
+}
+
+type hub struct {
+	workflowName string
+	serviceUri   string
+	cancel       context.CancelFunc
+
+	mu          sync.Mutex
+	workflow    infrastructure.Workflow // the workflow as of the latest subscription or result
+	subscribers map[*Subscription]struct{}
+	last        *Message
+}
+
+// Subscription receives every message broadcast by a workflow hub until it
+// is closed. the channel is closed if the subscriber falls too far behind
+type Subscription struct {
+	WorkflowName string
+
+	manager   *HubManager
+	hub       *hub
+	messages  chan Message
+	closeOnce sync.Once
+}
+
+func NewHubManager(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+	historyService *history.HistoryService,
+	webhookService *webhook.WebhookService,
+	dashboardCardData *types.DashboardCardData,
+) *HubManager {
+	return &HubManager{
+		Config:            config,
+		db:                db,
+		logger:            logger,
+		ctx:               ctx,
+		history:           historyService,
+		webhooks:          webhookService,
+		dashboardCardData: dashboardCardData,
+		hubs:              make(map[string]*hub),
+	}
+}
+
+// ErrNotDeployed is returned when subscribing to a workflow whose worker
+// has no service uri yet
+var ErrNotDeployed = errors.New("this workflow is not deployed yet")
+
+// Subscribe joins the hub for a workflow, starting its upstream connection
+// if this is the first subscriber. the last message the hub received is
+// sent to the new subscriber straight away
+func (m *HubManager) Subscribe(workflow infrastructure.Workflow) (*Subscription, error) {
+	if workflow.ServiceUri == "" {
+		return nil, ErrNotDeployed
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.hubs[workflow.Name]
+	if !ok {
+		h = &hub{
+			workflowName: workflow.Name,
+			subscribers:  make(map[*Subscription]struct{}),
+		}
+		m.hubs[workflow.Name] = h
+	}
+
+	if h.serviceUri != workflow.ServiceUri {
+		// either a new hub or the worker was moved, (re)connect upstream
+		if h.cancel != nil {
+			h.cancel()
+		}
+
+		ctx, cancel := context.WithCancel(m.ctx)
+		h.serviceUri = workflow.ServiceUri
+		h.cancel = cancel
+
+		go m.runUpstream(ctx, h, workflow.ServiceUri)
+	}
+
+	sub := &Subscription{
+		WorkflowName: workflow.Name,
+		manager:      m,
+		hub:          h,
+		messages:     make(chan Message, subscriberBufferSize),
+	}
+
+	h.mu.Lock()
+	h.workflow = workflow
+	h.subscribers[sub] = struct{}{}
+	if h.last != nil {
+		replay := *h.last
+		replay.Replay = true
+		sub.messages <- replay
+	}
+	h.mu.Unlock()
+
+	return sub, nil
+}
+
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Close leaves the hub, tearing down the upstream connection if this was
+// the last subscriber
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		m := s.manager
+		h := s.hub
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		h.mu.Lock()
+		if _, ok := h.subscribers[s]; ok {
+			delete(h.subscribers, s)
+			close(s.messages)
+		}
+		empty := len(h.subscribers) == 0
+		h.mu.Unlock()
+
+		if empty && m.hubs[h.workflowName] == h {
+			if h.cancel != nil {
+				h.cancel()
+			}
+			delete(m.hubs, h.workflowName)
+			m.logger.Info("closed workflow hub", "workflow-name", h.workflowName)
+		}
+	})
+}
+
+// Subscribers returns the number of subscribers to a workflow hub
+func (m *HubManager) Subscribers(workflowName string) int {
+	m.mu.Lock()
+	h, ok := m.hubs[workflowName]
+	m.mu.Unlock()
+
+	if !ok {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers)
+}
+
+func (h *hub) broadcast(message Message, logger *slog.Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last = &message
+
+	for sub := range h.subscribers {
+		select {
+		case sub.messages <- message:
+		default:
+			logger.Warn("dropping slow workflow subscriber", "workflow-name", h.workflowName)
+			delete(h.subscribers, sub)
+			close(sub.messages)
+		}
+	}
+}
+
+func (h *hub) isLast(data []byte) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.last != nil && bytes.Equal(h.last.Data, data)
+}
+
+func (m *HubManager) runUpstream(ctx context.Context, h *hub, serviceUri string) {
+	m.logger.Info("opened workflow hub", "workflow-name", h.workflowName)
+
+	for {
+		err := m.stream(ctx, h, serviceUri)
+		if err != nil && ctx.Err() == nil {
+			m.logger.Warn("workflow hub lost connection to worker", "workflow-name", h.workflowName, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(hubRetryInterval):
+		}
+	}
+}
+
+func (m *HubManager) stream(ctx context.Context, h *hub, serviceUri string) error {
+	targetUri, err := workerSocketUri(serviceUri)
+	if err != nil {
+		return err
+	}
+
+	serverConn, resp, err := websocket.DefaultDialer.DialContext(ctx, targetUri, nil)
+	if err != nil {
+		return err
+	}
+	defer serverConn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "connection closed")
+			serverConn.WriteMessage(websocket.CloseMessage, closeMsg)
+			serverConn.Close()
+		case <-done:
+		}
+	}()
+
+	// only a first message the worker marks as cached is a replay, workers
+	// that do not mark it have every message recorded
+	first := resp != nil && resp.Header.Get(cachedResultHeader) == "true"
+
+	for {
+		_, data, err := serverConn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if first && h.isLast(data) {
+			// subscribers already have this result from before the reconnect
+			first = false
+			continue
+		}
+
+		message, emit := m.record(h, data, first)
+		if emit {
+			h.broadcast(message, m.logger)
+		}
+
+		first = false
+	}
+}
+
+// record validates a message against the workflow schema and writes it to
+// history before it is broadcast so it carries its history id, then queues
+// it for the workflow webhooks. replayed results that were already recorded
+// reuse the stored result instead. results dropped for being invalid or
+// suppressed by change detection are recorded as dropped, and false is
+// returned so they are not broadcast
+func (m *HubManager) record(h *hub, data []byte, replay bool) (Message, bool) {
+	workflowName := h.workflowName
+	workflow := m.reload(h)
+
+	message := Message{
+		WorkflowName: workflowName,
+		ScrapedAt:    time.Now().UTC(),
+		Data:         data,
+		Replay:       replay,
+	}
+
+	if replay {
+		latest, err := m.history.Latest(workflowName)
+		if err != nil {
+			m.logger.Error("error loading latest workflow result", "workflow-name", workflowName, "err", err)
+		}
+
+		if latest != nil && latest.Raw == string(data) {
+			message.ID = latest.ID.Hex()
+			message.ScrapedAt = latest.ScrapedAt
+			message.Valid = latest.Valid
+			message.ValidationErrors = latest.ValidationErrors
+			message.Changed = latest.Changed
+			return message, !latest.Dropped
+		}
+	}
+
+	// dropped results are still recorded, they are only kept from
+	// subscribers and webhooks
+	dropped := false
+
+	if workflow.Schema.HasFields() {
+		validationErrors := workflow.Schema.ValidateResult(data)
+		valid := len(validationErrors) == 0
+
+		message.Valid = &valid
+		message.ValidationErrors = validationErrors
+
+		if !valid {
+			m.logger.Warn("workflow result failed schema validation", "workflow-name", workflowName, "errors", validationErrors, "dropped", workflow.DropInvalid)
+
+			err := infrastructure.RecordValidationFailure(m.ctx, m.db, m.Config.DatabaseName, workflowName, validationErrors)
+			if err != nil {
+				m.logger.Error("error counting validation failure", "workflow-name", workflowName, "err", err)
+			}
+
+			dropped = workflow.DropInvalid
+		}
+	}
+
+	if workflow.Changes.Enabled() && !dropped {
+		// compare with what subscribers last received
+		previous, err := m.history.LatestDelivered(workflowName)
+		if err != nil {
+			m.logger.Error("error loading latest workflow result", "workflow-name", workflowName, "err", err)
+		}
+
+		if previous != nil {
+			change := changes.Detect(workflow.Changes.KeyFields, []byte(previous.Raw), data)
+
+			message.Changed = &change.Changed
+			message.Diff = change.Diff
+
+			if !change.Changed && workflow.Changes.Mode == infrastructure.ChangeDetectionSuppress {
+				m.logger.Debug("suppressed unchanged workflow result", "workflow-name", workflowName)
+				dropped = true
+			}
+		}
+	}
+
+	result, err := m.history.Record(history.Result{
+		WorkflowName:     workflowName,
+		Raw:              string(data),
+		Valid:            message.Valid,
+		ValidationErrors: message.ValidationErrors,
+		Changed:          message.Changed,
+		Dropped:          dropped,
+	})
+	if err != nil {
+		m.logger.Error("error recording workflow result", "workflow-name", workflowName, "err", err)
+		return message, !dropped
+	}
+
+	m.dashboardCardData.DocScraped.Add(1)
+
+	message.ID = result.ID.Hex()
+	message.ScrapedAt = result.ScrapedAt
+
+	if dropped {
+		return message, false
+	}
+
+	payload, err := json.Marshal(NewEnvelope(workflow, message))
+	if err == nil {
+		err = m.webhooks.Enqueue(workflowName, message.ID, payload)
+	}
+	if err != nil {
+		m.logger.Error("error queueing webhook deliveries", "workflow-name", workflowName, "err", err)
+	}
+
+	return message, true
+}
+
+// reload reads the workflow from the database so results are handled with
+// its current schema and settings, which can change without the worker
+// moving. the workflow from the latest subscription is used if it fails
+func (m *HubManager) reload(h *hub) infrastructure.Workflow {
+	workflow, err := infrastructure.FindWorkflow(m.ctx, m.db, m.Config.DatabaseName, h.workflowName)
+	if err != nil {
+		m.logger.Error("error reloading workflow for its result", "workflow-name", h.workflowName, "err", err)
+		return h.snapshot()
+	}
+
+	h.mu.Lock()
+	h.workflow = *workflow
+	h.mu.Unlock()
+
+	return *workflow
+}
+
+func (h *hub) snapshot() infrastructure.Workflow {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.workflow
+}
@@ -0,0 +1,234 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	maxMultiplexSubscriptions = 100
+	maxControlMessageBytes    = 64 << 10
+	writeWait                 = 10 * time.Second
+)
+
+const (
+	actionSubscribe   = "subscribe"
+	actionUnsubscribe = "unsubscribe"
+)
+
+const (
+	messageTypeResult       = "result"
+	messageTypeSubscribed   = "subscribed"
+	messageTypeUnsubscribed = "unsubscribed"
+	messageTypeError        = "error"
+)
+
+// controlMessage is sent by clients of the multiplexed endpoint to change
+// which workflows they are subscribed to
+type controlMessage struct {
+	Action    string   `json:"action"`
+	Workflows []string `json:"workflows"`
+}
+
+// multiplexMessage wraps everything sent to clients of the multiplexed
+// endpoint. results are always sent as envelopes since there are no legacy
+// clients of this endpoint to keep working
+type multiplexMessage struct {
+	Type     string    `json:"type"`
+	Workflow string    `json:"workflow,omitempty"`
+	Result   *Envelope `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type multiplexConn struct {
+	ws   *WebsocketService
+	conn *websocket.Conn
+	key  auth.ApiKey
+
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	subs map[string]*Subscription // map[workflowName]subscription
+}
+
+// HandleMultiplexConnection serves clients that subscribe to any number of
+// workflows over a single websocket using control messages
+func (ws *WebsocketService) HandleMultiplexConnection(w http.ResponseWriter, r *http.Request) {
+	key, _ := auth.APIKeyFromContext(r.Context())
+
+	clientConn, err := upgradeClient(w, r)
+	if err != nil {
+		ws.logger.Error("error processing request", "svc", "connect/upgrade", "err", err)
+		return
+	}
+	defer clientConn.Close()
+
+	clientConn.SetReadLimit(maxControlMessageBytes)
+
+	mc := &multiplexConn{
+		ws:   ws,
+		conn: clientConn,
+		key:  key,
+		subs: make(map[string]*Subscription),
+	}
+	defer mc.closeAll()
+
+	ws.dashboardCardData.CliConnects.Add(1)
+	defer ws.dashboardCardData.CliConnects.Add(-1)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-ws.ctx.Done():
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			mc.writeControl(closeMsg)
+			clientConn.Close()
+		}
+	}()
+
+	for {
+		_, data, err := clientConn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var control controlMessage
+		if err := json.Unmarshal(data, &control); err != nil {
+			mc.send(multiplexMessage{Type: messageTypeError, Error: "invalid control message: " + err.Error()})
+			continue
+		}
+
+		for _, workflowName := range control.Workflows {
+			switch control.Action {
+			case actionSubscribe:
+				mc.subscribe(workflowName)
+			case actionUnsubscribe:
+				mc.unsubscribe(workflowName)
+			}
+		}
+
+		if control.Action != actionSubscribe && control.Action != actionUnsubscribe {
+			mc.send(multiplexMessage{Type: messageTypeError, Error: "unknown action " + control.Action})
+		}
+	}
+}
+
+func (mc *multiplexConn) subscribe(workflowName string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if _, ok := mc.subs[workflowName]; ok {
+		mc.send(multiplexMessage{Type: messageTypeSubscribed, Workflow: workflowName})
+		return
+	}
+
+	if len(mc.subs) >= maxMultiplexSubscriptions {
+		mc.send(multiplexMessage{Type: messageTypeError, Workflow: workflowName, Error: "too many subscriptions on this connection"})
+		return
+	}
+
+	workflow, err := infrastructure.FindWorkflow(mc.ws.ctx, mc.ws.db, mc.ws.Config.DatabaseName, workflowName)
+	if err != nil {
+		if err != infrastructure.ErrNoWorkflowExists {
+			mc.ws.logger.Error("error processing request", "svc", "connect/find", "err", err)
+		}
+
+		// unknown and out of scope workflows look the same to the client
+		mc.send(multiplexMessage{Type: messageTypeError, Workflow: workflowName, Error: "workflow not found"})
+		return
+	}
+
+	if !mc.key.Scopes.AllowsWorkflow(workflow.Name, workflow.Tags) {
+		mc.send(multiplexMessage{Type: messageTypeError, Workflow: workflowName, Error: "workflow not found"})
+		return
+	}
+
+	sub, err := mc.ws.hubs.Subscribe(*workflow)
+	if err != nil {
+		mc.send(multiplexMessage{Type: messageTypeError, Workflow: workflowName, Error: err.Error()})
+		return
+	}
+	mc.subs[workflowName] = sub
+
+	mc.send(multiplexMessage{Type: messageTypeSubscribed, Workflow: workflowName})
+
+	go mc.forward(sub, *workflow)
+}
+
+func (mc *multiplexConn) unsubscribe(workflowName string) {
+	mc.mu.Lock()
+	sub, ok := mc.subs[workflowName]
+	delete(mc.subs, workflowName)
+	mc.mu.Unlock()
+
+	if ok {
+		sub.Close()
+	}
+
+	mc.send(multiplexMessage{Type: messageTypeUnsubscribed, Workflow: workflowName})
+}
+
+func (mc *multiplexConn) forward(sub *Subscription, workflow infrastructure.Workflow) {
+	for message := range sub.Messages() {
+		envelope := NewEnvelope(workflow, message)
+
+		err := mc.send(multiplexMessage{
+			Type:     messageTypeResult,
+			Workflow: message.WorkflowName,
+			Result:   &envelope,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	// the channel is also closed when the client unsubscribes, only
+	// report subscriptions the hub dropped
+	mc.mu.Lock()
+	dropped := mc.subs[sub.WorkflowName] == sub
+	if dropped {
+		delete(mc.subs, sub.WorkflowName)
+	}
+	mc.mu.Unlock()
+
+	if dropped {
+		sub.Close()
+		mc.send(multiplexMessage{Type: messageTypeError, Workflow: sub.WorkflowName, Error: "subscription dropped because the client fell behind"})
+	}
+}
+
+func (mc *multiplexConn) send(message multiplexMessage) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+
+	mc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return mc.conn.WriteJSON(message)
+}
+
+func (mc *multiplexConn) writeControl(data []byte) {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+
+	mc.conn.WriteControl(websocket.CloseMessage, data, time.Now().Add(writeWait))
+}
+
+func (mc *multiplexConn) closeAll() {
+	mc.mu.Lock()
+	subs := mc.subs
+	mc.subs = make(map[string]*Subscription)
+	mc.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/infrastructure"
+	"github.com/ferretcode/scavenger/pkg/types"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const recorderRetryInterval = 10 * time.Second
+
+var errRecorderDropped = errors.New("recorder fell behind and was dropped by the workflow hub")
+
+// Recorder keeps a hub subscription open for every running workflow so
+// their results are recorded to history even when no client is subscribed
+type Recorder struct {
+	Config *types.ScavengerConfig
+	db     *mongo.Client
+	logger *slog.Logger
+	ctx    context.Context
+
+	hubs *HubManager
+
+	mu        sync.Mutex
+	listeners map[string]recorderListener // map[workflowName]listener
+}
+
+type recorderListener struct {
+	serviceUri string
+	cancel     context.CancelFunc
+}
+
+func NewRecorder(
+	config *types.ScavengerConfig,
+	db *mongo.Client,
+	logger *slog.Logger,
+	ctx context.Context,
+	hubs *HubManager,
+) *Recorder {
+	return &Recorder{
+		Config:    config,
+		db:        db,
+		logger:    logger,
+		ctx:       ctx,
+		hubs:      hubs,
+		listeners: make(map[string]recorderListener),
+	}
+}
+
+// Run syncs the recorder with the workflows collection every interval
+// until the recorder context is cancelled
+func (rec *Recorder) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := rec.sync(); err != nil {
+			rec.logger.Error("error syncing history recorder with workflows", "err", err)
+		}
+
+		select {
+		case <-rec.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rec *Recorder) sync() error {
+	cur, err := rec.db.Database(rec.Config.DatabaseName).Collection("workflows").Find(rec.ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(rec.ctx)
+
+	var workflows []infrastructure.Workflow
+	if err := cur.All(rec.ctx, &workflows); err != nil {
+		return err
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	desired := make(map[string]bool)
+
+	for _, workflow := range workflows {
+		if workflow.IsPaused() {
+			continue
+		}
+
+		desired[workflow.Name] = true
+
+		listener, ok := rec.listeners[workflow.Name]
+		if ok && listener.serviceUri == workflow.ServiceUri {
+			continue
+		}
+
+		if ok {
+			// the worker was moved, reconnect to the new uri
+			listener.cancel()
+		}
+
+		ctx, cancel := context.WithCancel(rec.ctx)
+		rec.listeners[workflow.Name] = recorderListener{
+			serviceUri: workflow.ServiceUri,
+			cancel:     cancel,
+		}
+
+		go rec.listen(ctx, workflow)
+	}
+
+	for workflowName, listener := range rec.listeners {
+		if !desired[workflowName] {
+			listener.cancel()
+			delete(rec.listeners, workflowName)
+		}
+	}
+
+	return nil
+}
+
+func (rec *Recorder) listen(ctx context.Context, workflow infrastructure.Workflow) {
+	rec.logger.Info("recording history for workflow", "workflow-name", workflow.Name)
+
+	for {
+		err := rec.hold(ctx, workflow)
+		if err != nil {
+			rec.logger.Warn("history recorder lost its workflow subscription", "workflow-name", workflow.Name, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			rec.logger.Info("stopped recording history for workflow", "workflow-name", workflow.Name)
+			return
+		case <-time.After(recorderRetryInterval):
+		}
+	}
+}
+
+// hold keeps a subscription open until the context is cancelled, the hub
+// records every message so there is nothing to do with them here
+func (rec *Recorder) hold(ctx context.Context, workflow infrastructure.Workflow) error {
+	sub, err := rec.hubs.Subscribe(workflow)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-sub.Messages():
+			if !ok {
+				return errRecorderDropped
+			}
+		}
+	}
+}
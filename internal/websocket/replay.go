@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/history"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// maxReplayResults caps last and is how many stored results are loaded at
+// a time when replaying a range
+const maxReplayResults = 1000
+
+// replayQuery asks for stored results to be sent before live streaming
+// starts, either everything since a time or the last n results
+type replayQuery struct {
+	since *time.Time
+	last  int64
+}
+
+func (q replayQuery) empty() bool {
+	return q.since == nil && q.last == 0
+}
+
+// parseReplayQuery reads the since and last query params. since accepts
+// an RFC 3339 timestamp or unix seconds
+func parseReplayQuery(r *http.Request) (replayQuery, error) {
+	query := r.URL.Query()
+	sinceParam := query.Get("since")
+	lastParam := query.Get("last")
+
+	if sinceParam != "" && lastParam != "" {
+		return replayQuery{}, errors.New("since and last cannot be used together")
+	}
+
+	if sinceParam != "" {
+		since, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			seconds, parseErr := strconv.ParseInt(sinceParam, 10, 64)
+			if parseErr != nil {
+				return replayQuery{}, errors.New("since must be an RFC 3339 timestamp or unix seconds")
+			}
+			since = time.Unix(seconds, 0)
+		}
+
+		since = since.UTC()
+		return replayQuery{since: &since}, nil
+	}
+
+	if lastParam != "" {
+		last, err := strconv.ParseInt(lastParam, 10, 64)
+		if err != nil || last <= 0 {
+			return replayQuery{}, errors.New("last must be a positive integer")
+		}
+
+		return replayQuery{last: min(last, maxReplayResults)}, nil
+	}
+
+	return replayQuery{}, nil
+}
+
+// allowsReplay reports whether the api key of the request may read stored
+// results, subscribing alone only gives access to live ones
+func allowsReplay(r *http.Request) bool {
+	key, ok := auth.APIKeyFromContext(r.Context())
+	return ok && key.Scopes.AllowsAction(auth.ScopeReadHistory)
+}
+
+// replayResults loads the results asked for by the query, oldest first.
+// for since only the first page is loaded, sendReplay reads the rest.
+// results that were dropped are never replayed
+func (ws *WebsocketService) replayResults(workflowName string, q replayQuery) ([]history.Result, error) {
+	if q.since != nil {
+		return ws.history.Since(workflowName, *q.since, maxReplayResults)
+	}
+
+	if q.last > 0 {
+		results, err := ws.history.ListDelivered(workflowName, q.last)
+		if err != nil {
+			return nil, err
+		}
+
+		slices.Reverse(results)
+		return results, nil
+	}
+
+	return nil, nil
+}
+
+// sendReplay sends replayed results to the client and returns the id of the
+// last one. when paged, a full page is followed by the results recorded
+// after it until none are left, so a range longer than maxReplayResults is
+// sent in full before live results
+func (ws *WebsocketService) sendReplay(workflowName string, results []history.Result, paged bool, send func(history.Result) error) (bson.ObjectID, error) {
+	var lastID bson.ObjectID
+
+	for {
+		for _, result := range results {
+			if err := send(result); err != nil {
+				return lastID, err
+			}
+
+			lastID = result.ID
+		}
+
+		if !paged || len(results) < maxReplayResults {
+			return lastID, nil
+		}
+
+		var err error
+		results, err = ws.history.After(workflowName, lastID, maxReplayResults)
+		if err != nil {
+			return lastID, err
+		}
+	}
+}
+
+// isNewerResult reports whether a live message was recorded after the last
+// result sent to the client. messages that were not recorded have no id and
+// are always sent
+func isNewerResult(messageID string, lastID bson.ObjectID) bool {
+	id, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return true
+	}
+
+	return bytes.Compare(id[:], lastID[:]) > 0
+}
@@ -2,17 +2,16 @@ package websocket
 
 import (
 	"context"
-	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"sync"
 
-	"github.com/ferretcode/scavenger/internal/workflow"
+	"github.com/ferretcode/scavenger/internal/auth"
+	"github.com/ferretcode/scavenger/internal/history"
+	"github.com/ferretcode/scavenger/internal/infrastructure"
 	"github.com/ferretcode/scavenger/pkg/types"
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
@@ -22,6 +21,8 @@ type WebsocketService struct {
 	logger *slog.Logger
 	ctx    context.Context
 
+	hubs              *HubManager
+	history           *history.HistoryService
 	dashboardCardData *types.DashboardCardData
 }
 
@@ -30,6 +31,8 @@ func NewWebsocketService(
 	db *mongo.Client,
 	logger *slog.Logger,
 	ctx context.Context,
+	hubs *HubManager,
+	historyService *history.HistoryService,
 	dashboardCardData *types.DashboardCardData,
 ) WebsocketService {
 	return WebsocketService{
@@ -37,143 +40,151 @@ func NewWebsocketService(
 		db:                db,
 		logger:            logger,
 		ctx:               ctx,
+		hubs:              hubs,
+		history:           historyService,
 		dashboardCardData: dashboardCardData,
 	}
 }
 
+// HandleWorkflowConnection streams the results of a workflow over a
+// websocket. since replays every stored result from an RFC 3339 timestamp
+// or unix seconds before live results, last replays the most recent n, up
+// to 1000. both need the read history scope
 func (ws *WebsocketService) HandleWorkflowConnection(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+	workflow, err := infrastructure.FindWorkflow(ws.ctx, ws.db, ws.Config.DatabaseName, chi.URLParam(r, "workflow_name"))
+	if err != nil {
+		if err == infrastructure.ErrNoWorkflowExists {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		handleError(err, w, "connect/find", ws.logger)
+		return
 	}
 
-	clientConn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		handleError(err, w, "connect/upgrade", ws.logger)
+	format, ok := messageFormat(r)
+	if !ok {
+		http.Error(w, "format must be raw or envelope", http.StatusBadRequest)
 		return
 	}
 
-	workflowName := chi.URLParam(r, "workflow_name")
-	filter := bson.D{{"name", workflowName}}
-	res := ws.db.Database("scavenger").Collection("workflows").FindOne(ws.ctx, filter)
-	workflow := workflow.Workflow{}
+	replay, err := parseReplayQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if res.Err() != nil {
-		clientConn.Close()
-		handleError(res.Err(), w, "connect/find", ws.logger)
+	if !replay.empty() && !allowsReplay(r) {
+		http.Error(w, "this api key is not allowed to "+auth.ScopeReadHistory, http.StatusForbidden)
 		return
 	}
 
-	err = res.Decode(&workflow)
+	// subscribe before reading history so nothing recorded in between is missed
+	sub, err := ws.hubs.Subscribe(*workflow)
 	if err != nil {
-		clientConn.Close()
-		handleError(err, w, "connect/decode", ws.logger)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	defer sub.Close()
 
-	serviceUri, err := url.Parse(workflow.ServiceUri)
+	replayed, err := ws.replayResults(workflow.Name, replay)
 	if err != nil {
-		clientConn.Close()
-		handleError(err, w, "connect/service", ws.logger)
+		handleError(err, w, "connect/history", ws.logger)
 		return
 	}
 
-	if serviceUri.Scheme == "https" {
-		serviceUri.Scheme = "wss"
-	} else {
-		serviceUri.Scheme = "ws"
+	clientConn, err := upgradeClient(w, r)
+	if err != nil {
+		ws.logger.Error("error processing request", "svc", "connect/upgrade", "err", err)
+		return
 	}
+	defer clientConn.Close()
 
-	targetUri := serviceUri.String() + "/ws"
-
-	dialer := websocket.DefaultDialer
-
-	serverConn, resp, err := dialer.Dial(targetUri, nil)
-	if err != nil {
-		clientConn.Close()
-		if resp != nil {
-			body, readErr := io.ReadAll(resp.Body)
-			if readErr != nil {
-				ws.logger.Error("handshake failed", "status", resp.StatusCode, "err", readErr)
-			} else {
-				ws.logger.Error("handshake failed", "status", resp.StatusCode, "body", string(body))
-			}
+	// the id of the last replayed result, live messages up to it are skipped
+	lastID, err := ws.sendReplay(workflow.Name, replayed, replay.since != nil, func(result history.Result) error {
+		if format == formatEnvelope {
+			return clientConn.WriteJSON(envelopeFromResult(*workflow, result))
 		}
-		handleError(err, w, "connect/connection", ws.logger)
+		return clientConn.WriteMessage(websocket.TextMessage, []byte(result.Raw))
+	})
+	if err != nil {
+		ws.logger.Error("replay to client failed", "err", err)
 		return
 	}
 
-	ws.dashboardCardData.CliConnects++
+	ws.dashboardCardData.CliConnects.Add(1)
+	defer ws.dashboardCardData.CliConnects.Add(-1)
 
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-
+	// the worker ignores client messages, reading only detects the
+	// client going away
 	go func() {
-		defer wg.Done()
 		defer cancel()
 
 		for {
-			select {
-			case <-ctx.Done():
+			if _, _, err := clientConn.ReadMessage(); err != nil {
 				return
-			default:
-				mt, message, err := clientConn.ReadMessage()
-				if err != nil {
-					ws.logger.Error("read from client failed", "err", err)
-					return
-				}
-
-				err = serverConn.WriteMessage(mt, message)
-				if err != nil {
-					ws.logger.Error("write to server failed", "err", err)
-					return
-				}
 			}
 		}
 	}()
 
-	go func() {
-		defer wg.Done()
-		defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ws.ctx.Done():
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			clientConn.WriteMessage(websocket.CloseMessage, closeMsg)
+			return
+		case message, ok := <-sub.Messages():
+			if !ok {
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow")
+				clientConn.WriteMessage(websocket.CloseMessage, closeMsg)
+				return
+			}
 
-		for {
-			select {
-			case <-ctx.Done():
+			if !lastID.IsZero() && !isNewerResult(message.ID, lastID) {
+				continue
+			}
+
+			if format == formatEnvelope {
+				err = clientConn.WriteJSON(NewEnvelope(*workflow, message))
+			} else {
+				err = clientConn.WriteMessage(websocket.TextMessage, message.Data)
+			}
+			if err != nil {
+				ws.logger.Error("write to client failed", "err", err)
 				return
-			default:
-				mt, message, err := serverConn.ReadMessage()
-				if err != nil {
-					ws.logger.Error("read from server failed", "err", err)
-					return
-				}
-
-				ws.dashboardCardData.DocScraped++
-
-				err = clientConn.WriteMessage(mt, message)
-				if err != nil {
-					ws.logger.Error("write to client failed", "err", err)
-					return
-				}
 			}
 		}
-	}()
+	}
+}
 
-	<-ctx.Done()
+func upgradeClient(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
 
-	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "connection closed")
-	clientConn.WriteMessage(websocket.CloseMessage, closeMsg)
-	serverConn.WriteMessage(websocket.CloseMessage, closeMsg)
+	return upgrader.Upgrade(w, r, nil)
+}
 
-	clientConn.Close()
-	serverConn.Close()
+func workerSocketUri(serviceUri string) (string, error) {
+	uri, err := url.Parse(serviceUri)
+	if err != nil {
+		return "", err
+	}
 
-	wg.Wait()
+	if uri.Scheme == "https" {
+		uri.Scheme = "wss"
+	} else {
+		uri.Scheme = "ws"
+	}
 
-	ws.dashboardCardData.CliConnects--
+	return uri.String() + "/ws", nil
 }
 
 func handleError(err error, w http.ResponseWriter, svc string, logger *slog.Logger) {
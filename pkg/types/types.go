@@ -1,36 +1,71 @@
 package types
 
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
 type ScavengerConfig struct {
-	DatabaseUrl        string `env:"DATABASE_URL"`
-	DatabaseName       string `env:"DATABASE_NAME"`
-	GcpProjectId       string `env:"GCP_PROJECT_ID"`
-	GcpCredentialsJson string `env:"GCP_CREDENTIALS_JSON"`
-	GcpLocation        string `env:"GCP_LOCATION"`
-	GeminiApiKey       string `env:"GEMINI_API_KEY"`
-	SessionsCookieName string `env:"SESSIONS_COOKIE_NAME"`
-	AdminUsername      string `env:"ADMIN_USERNAME"`
-	AdminPassword      string `env:"ADMIN_PASSWORD"`
-	Provider           string `env:"PROVIDER"`
-	WorkerImage        string `env:"WORKER_IMAGE"`
-	HeadlessApiKey     string `env:"HEADLESS_API_KEY"`
-	Mode               string `env:"MODE"`
+	DatabaseUrl           string        `env:"DATABASE_URL"`
+	DatabaseName          string        `env:"DATABASE_NAME"`
+	GcpProjectId          string        `env:"GCP_PROJECT_ID"`
+	GcpCredentialsJson    string        `env:"GCP_CREDENTIALS_JSON"`
+	GcpLocation           string        `env:"GCP_LOCATION"`
+	GeminiApiKey          string        `env:"GEMINI_API_KEY"`
+	SessionsCookieName    string        `env:"SESSIONS_COOKIE_NAME"`
+	SessionStore          string        `env:"SESSION_STORE" envDefault:"mongo"`
+	SessionTTL            time.Duration `env:"SESSION_TTL" envDefault:"24h"`
+	SessionCookieSecure   bool          `env:"SESSION_COOKIE_SECURE"`
+	SessionCookieSameSite string        `env:"SESSION_COOKIE_SAMESITE" envDefault:"lax"`
+	AdminUsername         string        `env:"ADMIN_USERNAME"`
+	AdminPassword         string        `env:"ADMIN_PASSWORD"`
+	Provider              string        `env:"PROVIDER"`
+	WorkerImage           string        `env:"WORKER_IMAGE"`
+	HeadlessApiKey        string        `env:"HEADLESS_API_KEY"`
+	Mode                  string        `env:"MODE"`
+	MaxConcurrentRuns     int           `env:"MAX_CONCURRENT_RUNS" envDefault:"4"`
+	ConfigPath            string        `env:"CONFIG_PATH" envDefault:"./config.json"`
+	ReconcileDryRun       bool          `env:"RECONCILE_DRY_RUN"`
 }
 
+// WorkflowsConfig is a workflow spec from config.json or the api. the result
+// fields are given either in Schema or as a JSON Schema document in JSONSchema
 type WorkflowsConfig struct {
-	Name    string                         `json:"name"`
-	Prompt  string                         `json:"prompt"`
-	Cron    string                         `json:"cron"`
-	Website string                         `json:"website"`
-	Schema  map[string]WorkflowSchemaField `json:"schema"`
+	Name            string                         `json:"name"`
+	Prompt          string                         `json:"prompt"`
+	Cron            string                         `json:"cron"`
+	Website         string                         `json:"website"`
+	Status          string                         `json:"status,omitempty"`
+	Tags            []string                       `json:"tags,omitempty"`
+	ChangeDetection *ChangeDetectionConfig         `json:"change_detection,omitempty"`
+	DropInvalid     bool                           `json:"drop_invalid,omitempty"`
+	Schema          map[string]WorkflowSchemaField `json:"schema"`
+	JSONSchema      json.RawMessage                `json:"json_schema,omitempty"`
+}
+
+type ChangeDetectionConfig struct {
+	Mode      string   `json:"mode"`
+	KeyFields []string `json:"key_fields,omitempty"`
 }
 
+// WorkflowSchemaField describes a field of the results of a workflow.
+// fields are required unless Optional is set. objects list their nested
+// fields in Properties and arrays describe their elements in Items
 type WorkflowSchemaField struct {
-	Name string `json:"title"`
-	Type string `json:"type"`
-	Desc string `json:"description"`
+	Name       string                         `json:"title"`
+	Type       string                         `json:"type"`
+	Desc       string                         `json:"description"`
+	Optional   bool                           `json:"optional,omitempty"`
+	Format     string                         `json:"format,omitempty"`
+	Enum       []any                          `json:"enum,omitempty"`
+	Properties map[string]WorkflowSchemaField `json:"properties,omitempty"`
+	Items      *WorkflowSchemaField           `json:"items,omitempty"`
 }
 
+// DashboardCardData holds counters updated by every hub and client
+// connection, so they are atomic
 type DashboardCardData struct {
-	DocScraped  int
-	CliConnects int
+	DocScraped  atomic.Int64
+	CliConnects atomic.Int64
 }
@@ -1,5 +1,6 @@
 import os
 import asyncio
+import hmac
 import json
 from aiohttp import web, WSMsgType
 from typing import Union
@@ -24,15 +25,26 @@ event_loop: Union[asyncio.AbstractEventLoop, None] = None
 async def websocket_handler(request):
     print("[WebSocket] Client connected")
     ws = web.WebSocketResponse()
-    await ws.prepare(request)
 
-    connected_websockets.add(ws)
+    # tells the control plane the first message is the result sent before,
+    # not a new scrape
+    cached_result = latest_result
+    if cached_result:
+        ws.headers["X-Scavenger-Cached-Result"] = "true"
+
+    await ws.prepare(request)
 
     try:
-        if latest_result:
-            await ws.send_str(latest_result)
+        if cached_result:
+            await ws.send_str(cached_result)
             print("[WebSocket] Sent cached result")
 
+        connected_websockets.add(ws)
+
+        # a scrape that finished while the cached result was sent
+        if latest_result is not cached_result:
+            await ws.send_str(latest_result)
+
         async for msg in ws:
             if msg.type == WSMsgType.TEXT:
                 pass  # Handle client messages here if needed
@@ -40,7 +52,7 @@ async def websocket_handler(request):
                 print(f"[WebSocket] Error: {ws.exception()}")
     finally:
         print("[WebSocket] Client disconnected")
-        connected_websockets.remove(ws)
+        connected_websockets.discard(ws)
 
     return ws
 
@@ -54,7 +66,8 @@ async def scraper_worker(run_config):
 
         while True:
             print("[Worker] Waiting for a task")
-            await scrape_queue.get()
+            # a task is either None or a future resolved once the scrape finishes
+            task = await scrape_queue.get()
             print("[Worker] Task received")
             try:
                 global latest_result
@@ -79,8 +92,13 @@ async def scraper_worker(run_config):
                 for ws in disconnected:
                     connected_websockets.remove(ws)
 
+                if task is not None and not task.done():
+                    task.set_result(None)
+
             except Exception as e:
                 print(f"[Scraper] Error running scraping: {e}")
+                if task is not None and not task.done():
+                    task.set_exception(e)
             finally:
                 scrape_queue.task_done()
 
@@ -89,13 +107,42 @@ async def scraper_worker(run_config):
 
 
 async def health_check(request):
-    return web.Response(text="OK")
+    # tells the control plane this worker accepts runs on /run, workers
+    # without the header are left to schedule their own runs
+    return web.Response(text="OK", headers={"X-Scavenger-Worker-Runs": "true"})
+
+
+async def run_handler(request):
+    # RUN_TOKEN is set by the control plane, a worker without one does not
+    # accept runs over http
+    run_token = os.getenv("RUN_TOKEN", "")
+    request_token = request.headers.get("X-Scavenger-Run-Token", "")
+    if not run_token or not hmac.compare_digest(request_token.encode(), run_token.encode()):
+        print("[Run] Rejected run request without a valid run token")
+        return web.json_response({"status": "unauthorized"}, status=401)
+
+    print("[Run] Run requested")
+
+    if request.query.get("wait") != "true":
+        await scrape_queue.put(None)
+        return web.json_response({"status": "queued"}, status=202)
+
+    task = asyncio.get_running_loop().create_future()
+    await scrape_queue.put(task)
+
+    try:
+        await task
+    except Exception as e:
+        return web.json_response({"status": "failed", "error": str(e)}, status=500)
+
+    return web.json_response({"status": "completed"})
 
 
 async def start_server():
     app = web.Application()
     app.router.add_get('/healthz', health_check)
     app.router.add_get('/ws', websocket_handler)
+    app.router.add_post('/run', run_handler)
 
     runner = web.AppRunner(app)
     await runner.setup()
@@ -108,8 +155,6 @@ async def main():
     global event_loop
     event_loop = asyncio.get_running_loop()
 
-    scheduler = BackgroundScheduler()
-    cron_trigger = CronTrigger.from_crontab(os.environ["CRONTAB"])
 
     run_config = CrawlerRunConfig(
         word_count_threshold=1,
@@ -131,8 +176,15 @@ async def main():
         print("[Scheduler] Enqueueing scrape task")
         event_loop.call_soon_threadsafe(scrape_queue.put_nowait, None)
 
-    scheduler.add_job(schedule_scrape, trigger=cron_trigger)
-    scheduler.start()
+    # runs are normally scheduled by the control plane through /run,
+    # CRONTAB is only used when running the worker on its own. the control
+    # plane still sends it for older worker images that require it
+    control_plane_scheduling = os.getenv("CONTROL_PLANE_SCHEDULING") == "true"
+    if os.getenv("CRONTAB") and not control_plane_scheduling:
+        scheduler = BackgroundScheduler()
+        cron_trigger = CronTrigger.from_crontab(os.environ["CRONTAB"])
+        scheduler.add_job(schedule_scrape, trigger=cron_trigger)
+        scheduler.start()
 
     await scrape_queue.put(None)
     asyncio.create_task(scraper_worker(run_config))